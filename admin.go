@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"owo.codes/whats-this/cdn-origin/lib/db"
+	"owo.codes/whats-this/cdn-origin/lib/redact"
+	"owo.codes/whats-this/cdn-origin/lib/signedurl"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+// inFlightRequests is the number of requests currently being handled by the
+// main HTTP server.
+var inFlightRequests int64
+
+// adminHandler handles requests to the admin API. All requests must be
+// authenticated with admin.token as a bearer token.
+func adminHandler(ctx *fasthttp.RequestCtx) {
+	token := viper.GetString("admin.token")
+	auth := ctx.Request.Header.Peek("Authorization")
+	if token == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+		adminError(ctx, fasthttp.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	switch path := string(ctx.Path()); {
+	case path == "/version" && ctx.IsGet():
+		writeJSON(ctx, fasthttp.StatusOK, map[string]string{"version": version})
+	case path == "/config" && ctx.IsGet():
+		writeJSON(ctx, fasthttp.StatusOK, redact.Config(viper.AllSettings()))
+	case path == "/requests" && ctx.IsGet():
+		writeJSON(ctx, fasthttp.StatusOK, map[string]int64{
+			"in_flight":        atomic.LoadInt64(&inFlightRequests),
+			"open_connections": int64(httpServer.GetOpenConnectionsCount()),
+		})
+	case path == "/loglevel" && ctx.IsGet():
+		writeJSON(ctx, fasthttp.StatusOK, map[string]int{"level": int(zerolog.GlobalLevel())})
+	case path == "/loglevel" && ctx.IsPost():
+		lvl, err := strconv.Atoi(string(ctx.QueryArgs().Peek("level")))
+		if err != nil || lvl < 0 || lvl > 5 {
+			adminError(ctx, fasthttp.StatusBadRequest, "level must be an integer between 0 and 5")
+			return
+		}
+		zerolog.SetGlobalLevel(zerolog.Level(lvl))
+		viper.Set("log.level", lvl)
+		log.Info().Int("level", lvl).Msg("log level changed by admin API")
+		writeJSON(ctx, fasthttp.StatusOK, map[string]int{"level": lvl})
+	case path == "/purge/thumbnail" && ctx.IsPost():
+		if thumbnailCache == nil {
+			adminError(ctx, fasthttp.StatusNotFound, "thumbnail cache is not enabled")
+			return
+		}
+		adminPurge(ctx, func(hash string) error {
+			err := thumbnailCache.DeleteThumbnail(hash)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		})
+	case path == "/purge/metadata" && ctx.IsPost():
+		if contentSniffer == nil {
+			adminError(ctx, fasthttp.StatusNotFound, "content sniffing is not enabled")
+			return
+		}
+		adminPurge(ctx, func(hash string) error {
+			contentSniffer.Delete(hash)
+			return nil
+		})
+	case path == "/sign" && ctx.IsGet():
+		if urlSigner == nil {
+			adminError(ctx, fasthttp.StatusNotFound, "signed URLs are not enabled")
+			return
+		}
+		adminSign(ctx)
+	default:
+		adminError(ctx, fasthttp.StatusNotFound, "not found")
+	}
+}
+
+// adminSign creates a signed URL path for the key query parameter, valid for
+// the duration in the ttl query parameter (1 hour by default, capped at
+// signedURLs.maxTTL).
+func adminSign(ctx *fasthttp.RequestCtx) {
+	key := string(ctx.QueryArgs().Peek("key"))
+	if key == "" {
+		adminError(ctx, fasthttp.StatusBadRequest, "key is required")
+		return
+	}
+	ttl := time.Hour
+	if v := ctx.QueryArgs().Peek("ttl"); len(v) != 0 {
+		var err error
+		ttl, err = time.ParseDuration(string(v))
+		if err != nil || ttl <= 0 {
+			adminError(ctx, fasthttp.StatusBadRequest, "ttl must be a positive duration")
+			return
+		}
+	}
+	if maxTTL := viper.GetDuration("signedURLs.maxTTL"); maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	path := "/" + strings.TrimPrefix(key, "/")
+	expires := time.Now().Add(ttl)
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Set(signedurl.ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	args.Set(signedurl.SignatureParam, urlSigner.Sign(viper.GetString("database.objectBucket"), path, expires))
+	signedURL := &url.URL{Path: path, RawQuery: args.String()}
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{
+		"url":     signedURL.String(),
+		"expires": expires.Unix(),
+	})
+}
+
+// adminPurge calls purge with the SHA256 hash of each object specified by the
+// key and hash query parameters.
+func adminPurge(ctx *fasthttp.RequestCtx, purge func(hash string) error) {
+	hashes := []string{}
+	for _, h := range ctx.QueryArgs().PeekMulti("hash") {
+		hash := strings.ToLower(string(h))
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+			adminError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid SHA256 hash: %s", h))
+			return
+		}
+		hashes = append(hashes, hash)
+	}
+	for _, key := range ctx.QueryArgs().PeekMulti("key") {
+		object, err := db.SelectObjectByBucketKey(viper.GetString("database.objectBucket"), string(key))
+		switch {
+		case err == sql.ErrNoRows:
+			adminError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("object not found: %s", key))
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("failed to run SELECT query on database")
+			adminError(ctx, fasthttp.StatusInternalServerError, "failed to fetch object from database")
+			return
+		}
+		if object.SHA256Hash != nil {
+			hashes = append(hashes, *object.SHA256Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		adminError(ctx, fasthttp.StatusBadRequest, "at least one key or hash is required")
+		return
+	}
+
+	for _, hash := range hashes {
+		if err := purge(hash); err != nil {
+			log.Warn().Err(err).Str("sha256_hash", hash).Msg("failed to purge cache entry")
+			adminError(ctx, fasthttp.StatusInternalServerError, "failed to purge cache entry")
+			return
+		}
+	}
+	writeJSON(ctx, fasthttp.StatusOK, map[string][]string{"purged": hashes})
+}
+
+// adminError sends a JSON error response.
+func adminError(ctx *fasthttp.RequestCtx, statusCode int, message string) {
+	writeJSON(ctx, statusCode, map[string]string{"error": message})
+}
+
+// writeJSON sends a JSON response.
+func writeJSON(ctx *fasthttp.RequestCtx, statusCode int, v interface{}) {
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json; charset=utf8")
+	if err := json.NewEncoder(ctx).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("failed to encode JSON response")
+	}
+}
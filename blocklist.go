@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"owo.codes/whats-this/cdn-origin/lib/blocklist"
+	"owo.codes/whats-this/cdn-origin/lib/db"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+var hashBlocklist *blocklist.Blocklist
+
+// setupBlocklist loads the hash blocklist from the configured file and/or
+// database table, and reloads it periodically.
+func setupBlocklist() error {
+	var loaders []blocklist.Loader
+	if path := viper.GetString("blocklist.file"); path != "" {
+		loaders = append(loaders, blocklist.FileLoader(path))
+	}
+	if viper.GetBool("blocklist.database") {
+		loaders = append(loaders, db.SelectBlockedHashes)
+	}
+	if len(loaders) == 0 {
+		return errors.New("blocklist.file or blocklist.database is required")
+	}
+
+	var err error
+	hashBlocklist, err = blocklist.New(loaders...)
+	if err != nil {
+		return err
+	}
+	if interval := viper.GetDuration("blocklist.reloadInterval"); interval > 0 {
+		hashBlocklist.Watch(interval)
+	}
+	log.Info().Int("hashes", hashBlocklist.Len()).Msg("Loaded hash blocklist")
+	return nil
+}
+
+// checkBlocklist checks a file object's hashes against the blocklist. If the
+// file is blocked, a 451 Unavailable For Legal Reasons or 410 Gone response
+// (see blocklist.status) is sent and false is returned.
+func checkBlocklist(ctx *fasthttp.RequestCtx, object db.Object) bool {
+	if hashBlocklist == nil {
+		return true
+	}
+	reason, blocked := hashBlocklist.Lookup(object.SHA256Hash, object.MD5Hash)
+	if !blocked {
+		return true
+	}
+	if reason == "" {
+		reason = viper.GetString("blocklist.reason")
+	}
+
+	ctx.SetUserValue("object_type", "blocked")
+	status := viper.GetInt("blocklist.status")
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("text/plain; charset=utf8")
+	fmt.Fprintf(ctx, "%d %s: %s\n\nReason: %s", status, fasthttp.StatusMessage(status), ctx.Path(), reason)
+	return false
+}
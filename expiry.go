@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"owo.codes/whats-this/cdn-origin/lib/clientip"
+	"owo.codes/whats-this/cdn-origin/lib/db"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+var moderationAllowlist []*net.IPNet
+
+// deleteObject turns a file or redirect object with deleted_at set into a
+// tombstone. If moderation.graceMode is enabled, deleted objects are still
+// served to clients in moderation.graceAllowlist, but never cached.
+func deleteObject(ctx *fasthttp.RequestCtx, object *db.Object) {
+	if object.ObjectType == 2 || object.DeletedAt == nil {
+		return
+	}
+	if viper.GetBool("moderation.graceMode") && clientip.Contains(moderationAllowlist, clientIP(ctx)) {
+		requestLogger(ctx).Debug().Str("key", object.Key).Msg("serving deleted object in grace mode")
+		ctx.Response.Header.Set("Cache-Control", "private, no-store")
+		return
+	}
+	object.ObjectType = 2
+}
+
+// expireObject turns an object which has expired or has no views left into a
+// tombstone with a generated reason. Responses for objects which will expire
+// are not cached past their expiry time (see setCacheControl), and
+// view-limited objects are never cached.
+func expireObject(ctx *fasthttp.RequestCtx, object *db.Object) {
+	if object.ObjectType == 2 {
+		return
+	}
+	now := time.Now()
+	switch {
+	case object.ExpiresAt != nil && !now.Before(*object.ExpiresAt):
+		tombstone(object, *object.ExpiresAt, "this object expired on "+object.ExpiresAt.UTC().Format(time.RFC1123))
+	case object.MaxViews != nil && *object.MaxViews <= 0:
+		tombstone(object, now, "this object has reached its view limit")
+	case object.MaxViews != nil:
+		ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	case object.ExpiresAt != nil:
+		ctx.SetUserValue("expires_at", *object.ExpiresAt)
+	}
+}
+
+// tombstone turns an object into a tombstone deleted at a time for a reason.
+func tombstone(object *db.Object, deletedAt time.Time, reason string) {
+	object.ObjectType = 2
+	object.DeletedAt = &deletedAt
+	object.DeleteReason = &reason
+}
+
+// countsAsView returns true if a request for a view-limited object sends its
+// content. Preview pages and revalidations don't count as views. If the
+// response honors byte ranges, range requests continuing a download (starting
+// after the first byte) don't count either, but any range which can include
+// the first byte (including suffix ranges) does.
+func countsAsView(ctx *fasthttp.RequestCtx, object db.Object, byteRanges bool) bool {
+	if ctx.QueryArgs().Has("preview") {
+		return false
+	}
+	if object.SHA256Hash != nil && strings.Trim(string(ctx.Request.Header.Peek("If-None-Match")), `"`) == *object.SHA256Hash {
+		return false
+	}
+	if byteRanges && rangeSkipsStart(string(ctx.Request.Header.Peek("Range"))) {
+		return false
+	}
+	return true
+}
+
+// rangeSkipsStart returns true if a Range header value is a byte range with a
+// start position after the first byte. Suffix ranges are served from the
+// start of short files by fasthttp.ParseByteRange, so they never skip it.
+func rangeSkipsStart(value string) bool {
+	if !strings.HasPrefix(value, "bytes=") {
+		return false
+	}
+	value = value[len("bytes="):]
+	i := strings.IndexByte(value, '-')
+	if i <= 0 {
+		return false
+	}
+	start, err := strconv.ParseUint(value[:i], 10, 64)
+	return err == nil && start > 0
+}
+
+// consumeView takes a view from a view-limited object. If no views are left,
+// the object is turned into a tombstone. The decrement happens in the
+// database, so the limit holds across multiple origin instances. If the
+// database query fails, an error response is sent and false is returned.
+func consumeView(ctx *fasthttp.RequestCtx, bucket, key string, object *db.Object) bool {
+	views, err := decrementViews(ctx, bucket, key)
+	switch {
+	case err == sql.ErrNoRows:
+		tombstone(object, time.Now(), "this object has reached its view limit")
+	case err != nil:
+		requestLogger(ctx).Error().Err(err).Msg("failed to run UPDATE query on database")
+		internalServerError(ctx)
+		return false
+	default:
+		object.MaxViews = &views
+	}
+	return true
+}
+
+// takeView takes a view from a view-limited object if the request counts as a
+// view. byteRanges must only be true if the response honors the Range header. It must be called right before the object's content is sent, after all
+// other checks, so rejected requests and bot previews don't use up views. If
+// no views are left, a tombstone response is sent and false is returned.
+func takeView(ctx *fasthttp.RequestCtx, bucket, key string, object *db.Object, byteRanges bool) bool {
+	if object.MaxViews == nil || !countsAsView(ctx, *object, byteRanges) {
+		return true
+	}
+	if !consumeView(ctx, bucket, key, object) {
+		return false
+	}
+	if object.ObjectType == 2 {
+		tombstoneHandler(ctx, *object)
+		return false
+	}
+	return true
+}
@@ -11,6 +11,7 @@ require (
 	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.3.1
 	github.com/valyala/fasthttp v1.2.0
+	golang.org/x/crypto v0.0.0-20181203042331-505ab145d0a9
 	golang.org/x/sys v0.0.0-20190222171317-cd391775e71e // indirect
 	gopkg.in/olivere/elastic.v5 v5.0.79
 )
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"owo.codes/whats-this/cdn-origin/lib/db"
+	"owo.codes/whats-this/cdn-origin/lib/thumbnailer"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+// healthCheck is the result of a single readiness check.
+type healthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// healthStatus is the response body of the health endpoints.
+type healthStatus struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// livenessHandler reports that the process is up.
+func livenessHandler(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	writeJSON(ctx, fasthttp.StatusOK, healthStatus{Status: "ok"})
+}
+
+// readinessHandler checks all dependencies concurrently and reports their
+// status. If any check fails, a 503 Service Unavailable response is sent.
+func readinessHandler(ctx *fasthttp.RequestCtx) {
+	timeout := viper.GetDuration("http.readyTimeout")
+	checkCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	checks := map[string]func() error{
+		"database": func() error {
+			return db.DB.PingContext(checkCtx)
+		},
+		"storage": func() error {
+			dir, err := os.Open(viper.GetString("files.storageLocation"))
+			if err != nil {
+				return err
+			}
+			defer dir.Close()
+			// An empty storage directory (such as on a fresh deployment) is
+			// still readable
+			if _, err = dir.Readdirnames(1); err == io.EOF {
+				return nil
+			}
+			return err
+		},
+	}
+	if viper.GetBool("thumbnails.enable") {
+		checks["thumbnailer"] = func() error {
+			return thumbnailer.Ping(viper.GetString("thumbnails.thumbnailerURL"), timeout)
+		}
+	}
+	if collector != nil {
+		checks["metrics"] = func() error {
+			return collector.Health(checkCtx)
+		}
+	}
+
+	status := healthStatus{Status: "ok", Checks: make(map[string]healthCheck, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			start := time.Now()
+			err := check()
+			result := healthCheck{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+				status.Status = "error"
+			}
+			status.Checks[name] = result
+		}(name, check)
+	}
+	wg.Wait()
+
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	if status.Status != "ok" {
+		writeJSON(ctx, fasthttp.StatusServiceUnavailable, status)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, status)
+}
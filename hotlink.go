@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"owo.codes/whats-this/cdn-origin/lib/hotlink"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+var hotlinkPolicy *hotlink.Policy
+
+// setupHotlink creates the hotlink protection policy from the hotlink
+// configuration.
+func setupHotlink() error {
+	action, err := hotlink.ParseAction(viper.GetString("hotlink.action"))
+	if err != nil {
+		return err
+	}
+	var rules []hotlink.Rule
+	if err := viper.UnmarshalKey("hotlink.rules", &rules); err != nil {
+		return fmt.Errorf("failed to parse hotlink.rules: %s", err)
+	}
+	hotlinkPolicy, err = hotlink.New(rules, action, viper.GetBool("hotlink.allowEmptyReferer"))
+	return err
+}
+
+// checkHotlink applies hotlink protection to a request for a file object. If
+// the request is blocked, the configured response is sent and false is
+// returned.
+func checkHotlink(ctx *fasthttp.RequestCtx, bucket, key string) bool {
+	if hotlinkPolicy == nil {
+		return true
+	}
+	allowed, action, applies := hotlinkPolicy.Check(
+		bucket,
+		string(ctx.Request.Header.Host()),
+		string(ctx.Request.Header.Referer()),
+		string(ctx.Request.Header.Peek("Origin")),
+	)
+	if !applies {
+		return true
+	}
+	addVary(ctx, "Referer", "Origin")
+	if allowed {
+		return true
+	}
+
+	ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	switch {
+	case action == hotlink.Placeholder && viper.GetString("hotlink.placeholderPath") != "":
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		serveFile(ctx, viper.GetString("hotlink.placeholderPath"))
+	case action == hotlink.Preview:
+		previewURL := &url.URL{Path: "/" + key, RawQuery: "preview"}
+		if q := signedQuery(ctx); q != "" {
+			previewURL.RawQuery += "&" + q
+		}
+		ctx.Redirect(previewURL.String(), fasthttp.StatusFound)
+	default:
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "403 Forbidden: hotlinking is not allowed")
+	}
+	return false
+}
+
+// addVary adds header names to the Vary response header.
+func addVary(ctx *fasthttp.RequestCtx, headers ...string) {
+	for _, h := range headers {
+		if v := ctx.Response.Header.Peek("Vary"); len(v) != 0 {
+			ctx.Response.Header.Set("Vary", string(v)+", "+h)
+		} else {
+			ctx.Response.Header.Set("Vary", h)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is an access log line format.
+type Format int
+
+const (
+	// JSON writes each entry as a JSON object on its own line.
+	JSON Format = iota
+
+	// Combined writes each entry in the Apache Combined Log Format, followed by
+	// the duration in milliseconds, object type and cache status.
+	Combined
+)
+
+// ParseFormat parses a format name from configuration ("json" or "combined").
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "json":
+		return JSON, nil
+	case "combined", "clf":
+		return Combined, nil
+	}
+	return JSON, fmt.Errorf("unknown access log format %q", name)
+}
+
+// Entry is a single access log entry.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	RemoteIP    string    `json:"remote_ip"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Method      string    `json:"method"`
+	Host        string    `json:"host"`
+	Key         string    `json:"key"`
+	Query       string    `json:"query,omitempty"`
+	Protocol    string    `json:"protocol"`
+	Status      int       `json:"status"`
+	Bytes       int64     `json:"bytes"`
+	DurationMS  float64   `json:"duration_ms"`
+	ObjectType  string    `json:"object_type,omitempty"`
+	CacheStatus string    `json:"cache_status,omitempty"`
+	Referer     string    `json:"referer,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+}
+
+// Logger writes access log entries to a file or stdout.
+type Logger struct {
+	path   string
+	format Format
+
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// New creates a new *Logger writing to the file at path, which is created if
+// it doesn't exist and appended to otherwise. If path is empty, "-" or
+// "stdout", entries are written to stdout instead.
+func New(path string, format Format) (*Logger, error) {
+	l := &Logger{path: path, format: format}
+	if l.isStdout() {
+		l.w = os.Stdout
+		return l, nil
+	}
+	if err := l.Reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) isStdout() bool {
+	return l.path == "" || l.path == "-" || l.path == "stdout"
+}
+
+// Reopen closes and reopens the log file, so log files can be rotated. It does
+// nothing when logging to stdout.
+func (l *Logger) Reopen() error {
+	if l.isStdout() {
+		return nil
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.w = file
+	return nil
+}
+
+// Log writes an entry to the log.
+func (l *Logger) Log(e *Entry) error {
+	var line []byte
+	switch l.format {
+	case Combined:
+		line = []byte(combinedLine(e))
+	default:
+		var err error
+		line, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(line)
+	return err
+}
+
+// combinedLine formats an entry in the Apache Combined Log Format with
+// additional fields.
+func combinedLine(e *Entry) string {
+	uri := "/" + e.Key
+	if e.Query != "" {
+		uri += "?" + e.Query
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s %s %s %.3f %s %s`,
+		orDash(e.RemoteIP),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, quote(uri), e.Protocol,
+		e.Status, bytes,
+		strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)),
+		e.DurationMS, orDash(e.ObjectType), orDash(e.CacheStatus))
+}
+
+// quote escapes quotes and control characters in a value without surrounding
+// it with quotes.
+func quote(s string) string {
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// TruncateIP zeroes all but the first v4Bits or v6Bits bits of an IP address
+// for privacy. Bit counts outside of the valid range leave the IP unchanged.
+func TruncateIP(ip net.IP, v4Bits, v6Bits int) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		if v4Bits < 0 || v4Bits > 32 {
+			return ip4
+		}
+		return ip4.Mask(net.CIDRMask(v4Bits, 32))
+	}
+	if ip == nil || v6Bits < 0 || v6Bits > 128 {
+		return ip
+	}
+	return ip.Mask(net.CIDRMask(v6Bits, 128))
+}
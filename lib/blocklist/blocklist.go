@@ -0,0 +1,129 @@
+package blocklist
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Loader loads blocked hashes, as a map of lowercase hex-encoded MD5 or SHA256
+// hashes to the reason they are blocked (which may be empty).
+type Loader func() (map[string]string, error)
+
+// Blocklist is a hot-reloadable set of blocked MD5 and SHA256 hashes merged
+// from one or more loaders.
+type Blocklist struct {
+	loaders []Loader
+
+	mu     sync.RWMutex
+	hashes map[string]string
+}
+
+// New creates a new *Blocklist and loads all loaders.
+func New(loaders ...Loader) (*Blocklist, error) {
+	b := &Blocklist{loaders: loaders}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload loads all loaders and replaces the blocked hashes. If any loader
+// fails, the current hashes are kept.
+func (b *Blocklist) Reload() error {
+	hashes := map[string]string{}
+	for _, load := range b.loaders {
+		loaded, err := load()
+		if err != nil {
+			return err
+		}
+		for hash, reason := range loaded {
+			hashes[hash] = reason
+		}
+	}
+	b.mu.Lock()
+	b.hashes = hashes
+	b.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the blocklist every interval in a new goroutine.
+func (b *Blocklist) Watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := b.Reload(); err != nil {
+				log.Warn().Err(err).Msg("failed to reload hash blocklist")
+			}
+		}
+	}()
+}
+
+// Len returns the number of blocked hashes.
+func (b *Blocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.hashes)
+}
+
+// Lookup checks whether any of the given hex-encoded hashes are blocked, and
+// returns the reason of the first blocked hash. Nil hashes are skipped.
+func (b *Blocklist) Lookup(hashes ...*string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, hash := range hashes {
+		if hash == nil {
+			continue
+		}
+		if reason, ok := b.hashes[strings.ToLower(*hash)]; ok {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// FileLoader returns a Loader reading a file with one hex-encoded MD5 or
+// SHA256 hash per line, optionally followed by whitespace and a reason. Empty
+// lines and lines starting with "#" are ignored.
+func FileLoader(path string) Loader {
+	return func() (map[string]string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		hashes := map[string]string{}
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			field := strings.Fields(text)[0]
+			hash := strings.ToLower(field)
+			if !ValidHash(hash) {
+				return nil, fmt.Errorf("%s:%d: invalid MD5 or SHA256 hash %q", path, line, field)
+			}
+			hashes[hash] = strings.TrimSpace(text[len(field):])
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return hashes, nil
+	}
+}
+
+// ValidHash returns true if s is a lowercase hex-encoded MD5 or SHA256 hash.
+func ValidHash(s string) bool {
+	if len(s) != 32 && len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
@@ -0,0 +1,123 @@
+package blocklist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	md5Hash    = "e2a81ac6617d7963bda5155239b4b262"
+	sha256Hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func TestFileLoader(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "empty",
+			content: "",
+			want:    map[string]string{},
+		},
+		{
+			name:    "hashes without reasons",
+			content: md5Hash + "\n" + sha256Hash + "\n",
+			want:    map[string]string{md5Hash: "", sha256Hash: ""},
+		},
+		{
+			name:    "reasons",
+			content: md5Hash + " known malware\n" + sha256Hash + "\t\tDMCA   takedown  \n",
+			want:    map[string]string{md5Hash: "known malware", sha256Hash: "DMCA   takedown"},
+		},
+		{
+			name:    "comments and blank lines",
+			content: "# blocked hashes\n\n   \n  # indented comment\n" + md5Hash + "\n",
+			want:    map[string]string{md5Hash: ""},
+		},
+		{
+			name:    "uppercase and surrounding whitespace",
+			content: "  E2A81AC6617D7963BDA5155239B4B262  reason\r\n",
+			want:    map[string]string{md5Hash: "reason"},
+		},
+		{
+			name:    "no trailing newline",
+			content: md5Hash,
+			want:    map[string]string{md5Hash: ""},
+		},
+		{
+			name:    "invalid length",
+			content: md5Hash + "\n" + md5Hash[:31] + "\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid hex",
+			content: "z2a81ac6617d7963bda5155239b4b262\n",
+			wantErr: true,
+		},
+	}
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i)))
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := FileLoader(path)()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FileLoader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FileLoader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileLoaderMissing(t *testing.T) {
+	if _, err := FileLoader(filepath.Join(os.TempDir(), "blocklist-does-not-exist"))(); err == nil {
+		t.Error("FileLoader() error = nil, want error for missing file")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	b, err := New(func() (map[string]string, error) {
+		return map[string]string{md5Hash: "", sha256Hash: "malware"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5 := md5Hash
+	upper := "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855"
+	other := "00000000000000000000000000000000"
+	tests := []struct {
+		name       string
+		hashes     []*string
+		wantReason string
+		wantOK     bool
+	}{
+		{"nil", []*string{nil, nil}, "", false},
+		{"not blocked", []*string{&other}, "", false},
+		{"blocked without reason", []*string{nil, &md5}, "", true},
+		{"blocked with reason", []*string{&upper}, "malware", true},
+		{"first blocked hash wins", []*string{&other, &upper, &md5}, "malware", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := b.Lookup(tt.hashes...)
+			if reason != tt.wantReason || ok != tt.wantOK {
+				t.Errorf("Lookup() = %q, %v, want %q, %v", reason, ok, tt.wantReason, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package cachecontrol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Policy is a Cache-Control policy for a class of responses.
+type Policy struct {
+	// MaxAge is the max-age directive in seconds. If MaxAge is negative and
+	// NoStore is false, no Cache-Control header is sent.
+	MaxAge int
+
+	// Private marks responses as only cacheable by the client.
+	Private bool
+
+	// Immutable marks responses as never changing during their freshness
+	// lifetime.
+	Immutable bool
+
+	// NoStore disallows caching entirely, all other fields are ignored.
+	NoStore bool
+
+	// StaleWhileRevalidate is the stale-while-revalidate directive in seconds
+	// (RFC 5861), omitted if zero.
+	StaleWhileRevalidate int
+
+	// StaleIfError is the stale-if-error directive in seconds (RFC 5861),
+	// omitted if zero.
+	StaleIfError int
+}
+
+// String returns the Cache-Control header value for the policy, or an empty
+// string if no header should be sent.
+func (p Policy) String() string {
+	if p.NoStore {
+		return "no-store"
+	}
+	if p.MaxAge < 0 {
+		return ""
+	}
+
+	directives := make([]string, 0, 5)
+	if p.Private {
+		directives = append(directives, "private")
+	} else {
+		directives = append(directives, "public")
+	}
+	directives = append(directives, "max-age="+strconv.Itoa(p.MaxAge))
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+	if p.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+strconv.Itoa(p.StaleWhileRevalidate))
+	}
+	if p.StaleIfError > 0 {
+		directives = append(directives, "stale-if-error="+strconv.Itoa(p.StaleIfError))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Table maps response classes to Cache-Control policies.
+type Table map[string]Policy
+
+// NewTable creates a Table from a map of response classes to policies.
+func NewTable(policies map[string]Policy) Table {
+	t := make(Table, len(policies))
+	for class, p := range policies {
+		t[class] = p
+	}
+	return t
+}
+
+// Get returns the Cache-Control header value for a response class, or false if
+// no header should be sent.
+func (t Table) Get(class string) (string, bool) {
+	p, ok := t[class]
+	if !ok {
+		return "", false
+	}
+	v := p.String()
+	return v, v != ""
+}
+
+// GetExpiring returns the Cache-Control header value for a response class
+// whose content expires in maxAge seconds. The class's max-age is capped at
+// maxAge and stale directives are dropped, so the response is never served
+// from a cache after it expires. Policies which disallow caching or only allow
+// private caching are kept as-is.
+func (t Table) GetExpiring(class string, maxAge int) string {
+	p, ok := t[class]
+	if !ok || p.MaxAge < 0 || p.MaxAge > maxAge {
+		p.MaxAge = maxAge
+	}
+	p.StaleWhileRevalidate = 0
+	p.StaleIfError = 0
+	return p.String()
+}
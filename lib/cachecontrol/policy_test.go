@@ -0,0 +1,53 @@
+package cachecontrol
+
+import "testing"
+
+func TestGetExpiring(t *testing.T) {
+	table := NewTable(map[string]Policy{
+		"file":     {MaxAge: 86400, Immutable: true, StaleWhileRevalidate: 60, StaleIfError: 3600},
+		"short":    {MaxAge: 60},
+		"private":  {MaxAge: 86400, Private: true},
+		"noStore":  {NoStore: true},
+		"noHeader": {MaxAge: -1},
+	})
+	tests := []struct {
+		class  string
+		maxAge int
+		want   string
+	}{
+		{"file", 300, "public, max-age=300, immutable"},
+		{"short", 300, "public, max-age=60"},
+		{"private", 300, "private, max-age=300"},
+		{"noStore", 300, "no-store"},
+		{"noHeader", 300, "public, max-age=300"},
+		{"unknown", 300, "public, max-age=300"},
+		{"file", 0, "public, max-age=0, immutable"},
+	}
+	for _, tt := range tests {
+		if got := table.GetExpiring(tt.class, tt.maxAge); got != tt.want {
+			t.Errorf("GetExpiring(%q, %d) = %q, want %q", tt.class, tt.maxAge, got, tt.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	table := NewTable(map[string]Policy{
+		"file":     {MaxAge: 86400, StaleIfError: 3600},
+		"noHeader": {MaxAge: -1},
+	})
+	tests := []struct {
+		class  string
+		want   string
+		wantOK bool
+	}{
+		{"file", "public, max-age=86400, stale-if-error=3600", true},
+		{"noHeader", "", false},
+		{"unknown", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := table.Get(tt.class)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Get(%q) = %q, %v, want %q, %v", tt.class, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
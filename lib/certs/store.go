@@ -0,0 +1,175 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Pair is a certificate and private key file pair.
+type Pair struct {
+	CertFile string `mapstructure:"certFile"`
+	KeyFile  string `mapstructure:"keyFile"`
+}
+
+// Store holds TLS certificates and selects one for each connection by SNI.
+// Certificates are reloaded when their files change on disk.
+type Store struct {
+	pairs []Pair
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	byName   map[string]*tls.Certificate
+	modTimes map[string]time.Time
+}
+
+// NewStore creates a new *Store and loads all certificate pairs.
+func NewStore(pairs []Pair) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("at least one certificate is required")
+	}
+	s := &Store{pairs: pairs}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load loads all certificate pairs, replacing the current certificates only if
+// all of them load successfully.
+func (s *Store) load() error {
+	certs := make([]*tls.Certificate, len(s.pairs))
+	byName := map[string]*tls.Certificate{}
+	modTimes := map[string]time.Time{}
+	for i, pair := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %s", pair.CertFile, err)
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate %s: %s", pair.CertFile, err)
+		}
+		certs[i] = &cert
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := byName[name]; !ok {
+				byName[name] = &cert
+			}
+		}
+		for _, file := range []string{pair.CertFile, pair.KeyFile} {
+			modTimes[file] = modTime(file)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs = certs
+	s.byName = byName
+	s.modTimes = modTimes
+	return nil
+}
+
+// GetCertificate returns the certificate for a TLS handshake, and can be used
+// as tls.Config.GetCertificate. Certificates are matched by exact name, then
+// by wildcard name, and the first certificate is used if none match.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return s.certs[0], nil
+}
+
+// Watch checks the certificate files for changes every interval in a new
+// goroutine, and reloads all certificates if any file changed. If reloading
+// fails, the current certificates are kept.
+func (s *Store) Watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if !s.changed() {
+				continue
+			}
+			if err := s.load(); err != nil {
+				log.Error().Err(err).Msg("failed to reload TLS certificates, keeping current certificates")
+				continue
+			}
+			log.Info().Msg("reloaded TLS certificates")
+		}
+	}()
+}
+
+// changed checks if any certificate file was modified since it was loaded.
+func (s *Store) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for file, t := range s.modTimes {
+		if !modTime(file).Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// modTime returns the modification time of a file (following symlinks), or the
+// zero time if it can't be determined.
+func modTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// ParseVersion parses a TLS version name ("1.0", "1.1", "1.2" or "1.3").
+func ParseVersion(name string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", name)
+}
+
+// ParseCipherSuites parses cipher suite names (such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) into their IDs.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,152 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver resolves the IP address of a client behind trusted proxies.
+type Resolver struct {
+	trusted      []*net.IPNet
+	trustAll     bool
+	headers      []string
+	useForwarded bool
+}
+
+// New creates a new *Resolver. Proxies are trusted if their address is in one
+// of trustedCIDRs (plain IP addresses are accepted as single-address ranges),
+// or always if trustedCIDRs is empty. Headers are single-address client IP
+// headers set by trusted proxies (such as CF-Connecting-IP), checked in order
+// before the forwarding headers. If useForwarded is true, the RFC 7239
+// Forwarded header is preferred over X-Forwarded-For.
+func New(trustedCIDRs []string, headers []string, useForwarded bool) (*Resolver, error) {
+	r := &Resolver{
+		trustAll:     len(trustedCIDRs) == 0,
+		headers:      headers,
+		useForwarded: useForwarded,
+	}
+	nets, err := ParseCIDRs(trustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	r.trusted = nets
+	return r, nil
+}
+
+// ParseCIDRs parses a list of CIDR ranges. Plain IP addresses are accepted as
+// single-address ranges.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q: %s", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Contains checks if an IP address is in any of the ranges.
+func Contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTrusted checks if an IP address belongs to a trusted proxy.
+func (r *Resolver) IsTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	return r.trustAll || Contains(r.trusted, ip)
+}
+
+// Resolve returns the client IP address for a request from the peer address
+// and a function returning request header values. If the peer isn't a trusted
+// proxy, the peer address is returned. Otherwise the configured client IP
+// headers are checked, followed by the forwarding chain, which is walked from
+// right to left until the first untrusted hop.
+func (r *Resolver) Resolve(peer net.IP, header func(name string) []byte) net.IP {
+	if !r.IsTrusted(peer) {
+		return peer
+	}
+
+	for _, h := range r.headers {
+		if ip := parseIP(string(header(h))); ip != nil {
+			return ip
+		}
+	}
+
+	var hops []string
+	if r.useForwarded {
+		hops = parseForwarded(string(header("Forwarded")))
+	}
+	if len(hops) == 0 {
+		if xff := string(header("X-Forwarded-For")); xff != "" {
+			hops = strings.Split(xff, ",")
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !r.IsTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// parseForwarded returns the `for` values of each element of an RFC 7239
+// Forwarded header.
+func parseForwarded(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var hops []string
+	for _, element := range strings.Split(value, ",") {
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				hops = append(hops, strings.Trim(kv[1], `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// parseIP parses an IP address which may be surrounded by whitespace, wrapped
+// in brackets or followed by a port.
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+}
@@ -0,0 +1,144 @@
+package clientip
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"for=192.0.2.60", []string{"192.0.2.60"}},
+		{`for=192.0.2.43, for="[2001:db8:cafe::17]:4711"`, []string{"192.0.2.43", "[2001:db8:cafe::17]:4711"}},
+		{"for=192.0.2.60;proto=http;by=203.0.113.43", []string{"192.0.2.60"}},
+		{"proto=https;For=198.51.100.17, by=203.0.113.43", []string{"198.51.100.17"}},
+		{"by=203.0.113.43", nil},
+	}
+	for _, tt := range tests {
+		if got := parseForwarded(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseForwarded(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		trusted      []string
+		headers      []string
+		useForwarded bool
+		peer         string
+		request      map[string]string
+		want         string
+	}{
+		{
+			name:    "untrusted peer ignores headers",
+			trusted: []string{"10.0.0.0/8"},
+			peer:    "192.0.2.1",
+			request: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "192.0.2.1",
+		},
+		{
+			name:    "trusted peer without headers",
+			trusted: []string{"10.0.0.0/8"},
+			peer:    "10.0.0.1",
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "client IP header",
+			trusted: []string{"10.0.0.1"},
+			headers: []string{"CF-Connecting-IP"},
+			peer:    "10.0.0.1",
+			request: map[string]string{"CF-Connecting-IP": "198.51.100.1", "X-Forwarded-For": "198.51.100.2"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "invalid client IP header falls back to forwarding chain",
+			trusted: []string{"10.0.0.1"},
+			headers: []string{"CF-Connecting-IP"},
+			peer:    "10.0.0.1",
+			request: map[string]string{"CF-Connecting-IP": "nope", "X-Forwarded-For": "198.51.100.2"},
+			want:    "198.51.100.2",
+		},
+		{
+			name:    "forwarding chain stops at first untrusted hop",
+			trusted: []string{"10.0.0.0/8"},
+			peer:    "10.0.0.1",
+			request: map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "forwarding chain of trusted hops",
+			trusted: []string{"10.0.0.0/8"},
+			peer:    "10.0.0.1",
+			request: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "invalid hop stops the chain",
+			trusted: []string{"10.0.0.0/8"},
+			peer:    "10.0.0.1",
+			request: map[string]string{"X-Forwarded-For": "198.51.100.1, garbage, 10.0.0.2"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:         "forwarded header preferred",
+			trusted:      []string{"10.0.0.0/8"},
+			useForwarded: true,
+			peer:         "10.0.0.1",
+			request: map[string]string{
+				"Forwarded":       `for="[2001:db8::1]:4711", for=10.0.0.2`,
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:         "forwarded header falls back to X-Forwarded-For",
+			trusted:      []string{"10.0.0.0/8"},
+			useForwarded: true,
+			peer:         "10.0.0.1",
+			request:      map[string]string{"X-Forwarded-For": "198.51.100.1:1234"},
+			want:         "198.51.100.1",
+		},
+		{
+			name:    "forwarded header ignored when disabled",
+			trusted: []string{"10.0.0.0/8"},
+			peer:    "10.0.0.1",
+			request: map[string]string{"Forwarded": "for=198.51.100.1"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "empty trusted list trusts all",
+			peer:    "192.0.2.1",
+			request: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.trusted, tt.headers, tt.useForwarded)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			header := func(name string) []byte {
+				return []byte(tt.request[name])
+			}
+			got := r.Resolve(net.ParseIP(tt.peer), header)
+			if !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidCIDR(t *testing.T) {
+	for _, cidr := range []string{"not an ip", "10.0.0.0/33"} {
+		if _, err := New([]string{cidr}, nil, false); err == nil {
+			t.Errorf("New(%q) error = nil, want error", cidr)
+		}
+	}
+}
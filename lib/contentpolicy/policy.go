@@ -0,0 +1,162 @@
+package contentpolicy
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// Action is the action taken when serving user content of a specific type.
+type Action int
+
+const (
+	// Inline serves content as-is with the stored content type.
+	Inline Action = iota
+
+	// PlainText serves content with a text/plain content type so browsers
+	// render it as text.
+	PlainText
+
+	// Attachment serves content with the stored content type, but forces
+	// browsers to download it.
+	Attachment
+)
+
+// ParseAction parses an action name from configuration ("inline", "text" or
+// "attachment").
+func ParseAction(name string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "inline", "none":
+		return Inline, nil
+	case "text", "plain", "text/plain":
+		return PlainText, nil
+	case "attachment", "download":
+		return Attachment, nil
+	}
+	return Inline, fmt.Errorf("unknown content policy action %q", name)
+}
+
+// DefaultRiskyTypes are content types which can execute scripts in the
+// origin's domain when rendered by a browser.
+var DefaultRiskyTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"image/svg+xml",
+	"text/xml",
+	"application/xml",
+	"*+xml",
+	"text/xsl",
+	"text/javascript",
+	"application/javascript",
+	"application/x-javascript",
+	"application/ecmascript",
+	"text/ecmascript",
+	"application/wasm",
+	"application/x-shockwave-flash",
+}
+
+// Policy determines how user content of each content type is served.
+type Policy struct {
+	riskyAction Action
+	riskyTypes  []string
+	overrides   []override
+}
+
+// override is a content type pattern with the action it overrides to.
+type override struct {
+	pattern string
+	action  Action
+}
+
+// New creates a new *Policy. Risky types are served with riskyAction, and
+// overrides take precedence over it. Types may be exact (`text/html`),
+// wildcard subtypes (`text/*`) or structured syntax suffixes (`*+xml`).
+// When several overrides match a type, the most specific one wins.
+func New(riskyTypes []string, riskyAction Action, overrides map[string]Action) *Policy {
+	p := &Policy{
+		riskyAction: riskyAction,
+		riskyTypes:  make([]string, len(riskyTypes)),
+		overrides:   make([]override, 0, len(overrides)),
+	}
+	for i, t := range riskyTypes {
+		p.riskyTypes[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+	for t, a := range overrides {
+		p.overrides = append(p.overrides, override{
+			pattern: strings.ToLower(strings.TrimSpace(t)),
+			action:  a,
+		})
+	}
+	sort.Slice(p.overrides, func(i, j int) bool {
+		a, b := p.overrides[i].pattern, p.overrides[j].pattern
+		if ra, rb := patternRank(a), patternRank(b); ra != rb {
+			return ra < rb
+		}
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	return p
+}
+
+// Action returns the action to take when serving content of the specified
+// content type. Unparseable content types are treated as risky.
+func (p *Policy) Action(contentType string) Action {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return p.riskyAction
+	}
+	for _, o := range p.overrides {
+		if matchType(o.pattern, typ) {
+			return o.action
+		}
+	}
+	for _, pattern := range p.riskyTypes {
+		if matchType(pattern, typ) {
+			return p.riskyAction
+		}
+	}
+	return Inline
+}
+
+// IsRisky returns true if the content type is in the risky types list,
+// regardless of overrides.
+func (p *Policy) IsRisky(contentType string) bool {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	for _, pattern := range p.riskyTypes {
+		if matchType(pattern, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchType checks if a lowercase media type matches a type pattern.
+func matchType(pattern, typ string) bool {
+	switch {
+	case pattern == typ:
+		return true
+	case strings.HasPrefix(pattern, "*+"):
+		return strings.HasSuffix(typ, pattern[1:])
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(typ, pattern[:len(pattern)-1])
+	}
+	return false
+}
+
+// patternRank orders type patterns from most to least specific: exact types,
+// then wildcard subtypes, then structured syntax suffixes.
+func patternRank(pattern string) int {
+	switch {
+	case strings.HasSuffix(pattern, "/*"):
+		return 1
+	case strings.HasPrefix(pattern, "*+"):
+		return 2
+	}
+	return 0
+}
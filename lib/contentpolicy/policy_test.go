@@ -0,0 +1,95 @@
+package contentpolicy
+
+import "testing"
+
+func TestAction(t *testing.T) {
+	p := New(DefaultRiskyTypes, Attachment, map[string]Action{
+		"*+xml":                 PlainText,
+		"image/*":               Attachment,
+		"image/svg+xml":         Inline,
+		"text/*":                PlainText,
+		"application/*":         Attachment,
+		"application/atom+xml":  Inline,
+		"application/vnd.a+xml": Attachment,
+	})
+	tests := []struct {
+		contentType string
+		want        Action
+	}{
+		// exact overrides beat wildcard and suffix overrides
+		{"image/svg+xml", Inline},
+		{"application/atom+xml", Inline},
+		{"application/vnd.a+xml", Attachment},
+
+		// wildcard subtypes beat suffixes
+		{"image/x-other+xml", Attachment},
+		{"application/rss+xml", Attachment},
+		{"text/html", PlainText},
+		{"text/html; charset=utf-8", PlainText},
+
+		// suffixes
+		{"model/x3d+xml", PlainText},
+
+		// no override
+		{"video/mp4", Inline},
+		{"IMAGE/PNG", Attachment},
+
+		// unparseable types are risky
+		{"", Attachment},
+		{"not a type", Attachment},
+	}
+	for _, tt := range tests {
+		if got := p.Action(tt.contentType); got != tt.want {
+			t.Errorf("Action(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestActionSpecificity(t *testing.T) {
+	tests := []struct {
+		name        string
+		overrides   map[string]Action
+		contentType string
+		want        Action
+	}{
+		{"exact beats wildcard", map[string]Action{"text/*": Attachment, "text/html": Inline}, "text/html", Inline},
+		{"exact beats suffix", map[string]Action{"*+xml": Attachment, "image/svg+xml": Inline}, "image/svg+xml", Inline},
+		{"wildcard beats suffix", map[string]Action{"*+xml": Attachment, "image/*": Inline}, "image/svg+xml", Inline},
+		{"longer suffix beats shorter", map[string]Action{"*+xml": Attachment, "*+svg+xml": Inline}, "image/x+svg+xml", Inline},
+		{"override beats risky type", map[string]Action{"text/html": PlainText}, "text/html", PlainText},
+		{"risky without override", map[string]Action{"image/*": Inline}, "text/html", Attachment},
+		{"default", nil, "image/png", Inline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Repeat to catch order depending on map iteration
+			for i := 0; i < 20; i++ {
+				p := New(DefaultRiskyTypes, Attachment, tt.overrides)
+				if got := p.Action(tt.contentType); got != tt.want {
+					t.Fatalf("Action(%q) = %v, want %v", tt.contentType, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRisky(t *testing.T) {
+	p := New(DefaultRiskyTypes, PlainText, map[string]Action{"text/html": Inline})
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/html", true},
+		{"text/html; charset=utf-8", true},
+		{"application/rss+xml", true},
+		{"image/svg+xml", true},
+		{"image/png", false},
+		{"text/plain", false},
+		{"", true},
+	}
+	for _, tt := range tests {
+		if got := p.IsRisky(tt.contentType); got != tt.want {
+			t.Errorf("IsRisky(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
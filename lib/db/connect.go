@@ -2,14 +2,23 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 
-	// postgres driver for database/sql
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // DB is the current database connection.
 var DB *sql.DB
 
+// columns is the set of optional columns which exist in the objects table.
+var columns = map[string]bool{}
+
+// Object queries with the optional columns filled in by detectColumns.
+var (
+	objectByBucketKeyQuery      string
+	fileObjectBySHA256HashQuery string
+)
+
 // Connect to the database using the given driver and connection URL.
 func Connect(driver string, connectionURL string) error {
 	var err error
@@ -17,5 +26,50 @@ func Connect(driver string, connectionURL string) error {
 	if err != nil {
 		return err
 	}
-	return DB.Ping()
+	if err := DB.Ping(); err != nil {
+		return err
+	}
+	return detectColumns()
+}
+
+// HasColumn returns true if an optional column exists in the objects table.
+func HasColumn(name string) bool {
+	return columns[name]
+}
+
+// detectColumns checks which optional columns exist in the objects table and
+// builds the object queries accordingly.
+func detectColumns() error {
+	names := make([]string, len(optionalColumns))
+	for i, c := range optionalColumns {
+		names[i] = c.Name
+	}
+	rows, err := DB.Query(selectObjectsColumns, pq.Array(names))
+	if err != nil {
+		return fmt.Errorf("failed to detect optional columns: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to detect optional columns: %s", err)
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to detect optional columns: %s", err)
+	}
+
+	selects, excludes := "", ""
+	for _, c := range optionalColumns {
+		if columns[c.Name] {
+			selects += ",\n\t" + c.Name
+			excludes += " AND\n\t" + c.Exclude
+		} else {
+			selects += ",\n\tNULL"
+		}
+	}
+	objectByBucketKeyQuery = fmt.Sprintf(selectObjectByBucketKey, selects)
+	fileObjectBySHA256HashQuery = fmt.Sprintf(selectFileObjectByBucketSHA256Hash, selects, excludes)
+	return nil
 }
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// Listen opens a dedicated connection to the database using the given
+// connection URL and calls handler with the payload of every notification
+// received on the channel. Notifications are handled sequentially in a new
+// goroutine. The connection is reestablished automatically if it is lost, and
+// notifications sent in the meantime are missed.
+func Listen(connectionURL, channel string, handler func(payload string)) error {
+	listener := pq.NewListener(connectionURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn().Err(err).Str("channel", channel).Msg("database listener connection event")
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case n, ok := <-listener.Notify:
+				if !ok {
+					return
+				}
+				// A nil notification is sent after the connection is
+				// reestablished.
+				if n != nil {
+					handler(n.Extra)
+				}
+			case <-time.After(time.Minute * 2):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
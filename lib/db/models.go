@@ -6,11 +6,20 @@ import (
 
 // Object represents a partial object from the database.
 type Object struct {
+	BucketKey       string     `json:"bucket_key"`
+	Key             string     `json:"key"`
+	Dir             string     `json:"dir"`
 	ContentType     *string    `json:"content_type"`
+	ContentLength   *int64     `json:"content_length"`
+	CreatedAt       time.Time  `json:"created_at"`
 	DestURL         *string    `json:"dest_url"`
 	ObjectType      int        `json:"object_type"`
 	DeletedAt       *time.Time `json:"deleted_at"`
 	DeleteReason    *string    `json:"delete_reason"`
+	AssociatedUser  *string    `json:"associated_user"`
+	PasswordHash    *string    `json:"-"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	MaxViews        *int64     `json:"max_views"`
 	MD5HashBytes    []byte     `json:"-"`
 	SHA256HashBytes []byte     `json:"-"`
 
@@ -4,14 +4,64 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/lib/pq"
 )
 
 // SelectObjectByBucketKey returns an object from a bucket and a key.
 func SelectObjectByBucketKey(bucket, key string) (Object, error) {
+	return scanObject(DB.QueryRow(objectByBucketKeyQuery, fmt.Sprintf("%s/%s", bucket, key)))
+}
+
+// SelectFileObjectBySHA256Hash returns a non-deleted file object from a bucket
+// with the specified SHA256 hash. If multiple objects reference the same hash,
+// any one of them may be returned.
+func SelectFileObjectBySHA256Hash(bucket string, sha256Hash []byte) (Object, error) {
+	return scanObject(DB.QueryRow(fileObjectBySHA256HashQuery, bucket, sha256Hash))
+}
+
+// DecrementViews atomically takes one view from a view-limited object and
+// returns the number of views left. sql.ErrNoRows is returned if the object
+// has no views left.
+func DecrementViews(bucket, key string) (int64, error) {
+	var views int64
+	err := DB.QueryRow(decrementObjectViews, fmt.Sprintf("%s/%s", bucket, key)).Scan(&views)
+	return views, err
+}
+
+// SelectBlockedHashes returns all hashes in the blocked_hashes table, as a map
+// of hex-encoded MD5 or SHA256 hashes to the reason they are blocked. Rows
+// with hashes of other lengths are skipped.
+func SelectBlockedHashes() (map[string]string, error) {
+	rows, err := DB.Query(selectBlockedHashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := map[string]string{}
+	for rows.Next() {
+		var hash []byte
+		var reason sql.NullString
+		if err := rows.Scan(&hash, &reason); err != nil {
+			return nil, err
+		}
+		if len(hash) == 16 || len(hash) == 32 {
+			hashes[hex.EncodeToString(hash)] = reason.String
+		}
+	}
+	return hashes, rows.Err()
+}
+
+// scanObject scans a row returned by one of the object SELECT queries into an
+// Object.
+func scanObject(row *sql.Row) (Object, error) {
 	var object Object
 
+	var bucketKey string
+	var key string
+	var dir string
 	var contentType sql.NullString
 	var destURL sql.NullString
 	var objectType int
@@ -19,13 +69,22 @@ func SelectObjectByBucketKey(bucket, key string) (Object, error) {
 	var deleteReason sql.NullString
 	var md5Hash []byte
 	var sha256Hash []byte
-	err := DB.QueryRow(selectObjectByBucketKey, fmt.Sprintf("%s/%s", bucket, key)).
-		Scan(&contentType, &destURL, &objectType, &deletedAt, &deleteReason, &md5Hash, &sha256Hash)
+	var contentLength sql.NullInt64
+	var createdAt time.Time
+	var associatedUser sql.NullString
+	var passwordHash sql.NullString
+	var expiresAt pq.NullTime
+	var maxViews sql.NullInt64
+	err := row.Scan(&bucketKey, &key, &dir, &contentType, &destURL, &objectType, &deletedAt, &deleteReason, &md5Hash,
+		&sha256Hash, &contentLength, &createdAt, &associatedUser, &passwordHash, &expiresAt, &maxViews)
 	if err != nil {
 		return object, err
 	}
 
 	// Populate object values
+	object.BucketKey = bucketKey
+	object.Key = key
+	object.Dir = dir
 	if contentType.Valid {
 		object.ContentType = &contentType.String
 	}
@@ -48,6 +107,22 @@ func SelectObjectByBucketKey(bucket, key string) (Object, error) {
 		sha256String := hex.EncodeToString(sha256Hash)
 		object.SHA256Hash = &sha256String
 	}
+	if associatedUser.Valid {
+		object.AssociatedUser = &associatedUser.String
+	}
+	if contentLength.Valid {
+		object.ContentLength = &contentLength.Int64
+	}
+	if passwordHash.Valid && passwordHash.String != "" {
+		object.PasswordHash = &passwordHash.String
+	}
+	if expiresAt.Valid {
+		object.ExpiresAt = &expiresAt.Time
+	}
+	if maxViews.Valid {
+		object.MaxViews = &maxViews.Int64
+	}
 	object.ObjectType = objectType
+	object.CreatedAt = createdAt
 	return object, nil
 }
@@ -1,17 +1,94 @@
 package db
 
+// optionalColumns are columns of the objects table which may not exist in
+// older databases, with the condition excluding objects using the column from
+// content-addressed lookups. Object queries select NULL in place of missing
+// columns.
+var optionalColumns = []struct {
+	Name    string
+	Exclude string
+}{
+	{"password_hash", "password_hash IS NULL"},
+	{"expires_at", "expires_at IS NULL"},
+	{"max_views", "max_views IS NULL"},
+}
+
+// selectObjectsColumns returns which of the given columns exist in the objects
+// table.
+var selectObjectsColumns = `
+SELECT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	table_schema = current_schema() AND
+	table_name = 'objects' AND
+	column_name = ANY($1)
+`
+
 var selectObjectByBucketKey = `
 SELECT
+	bucket_key,
+	"key",
+	dir,
 	content_type,
 	dest_url,
 	"type",
 	deleted_at,
 	delete_reason,
 	md5_hash,
-	sha256_hash
+	sha256_hash,
+	content_length,
+	created_at,
+	associated_user%s
 FROM
 	objects
 WHERE
 	bucket_key = $1
 LIMIT 1
 `
+
+var selectFileObjectByBucketSHA256Hash = `
+SELECT
+	bucket_key,
+	"key",
+	dir,
+	content_type,
+	dest_url,
+	"type",
+	deleted_at,
+	delete_reason,
+	md5_hash,
+	sha256_hash,
+	content_length,
+	created_at,
+	associated_user%s
+FROM
+	objects
+WHERE
+	bucket = $1 AND
+	sha256_hash = $2 AND
+	"type" = 0 AND
+	deleted_at IS NULL%s
+LIMIT 1
+`
+
+var decrementObjectViews = `
+UPDATE
+	objects
+SET
+	max_views = max_views - 1
+WHERE
+	bucket_key = $1 AND
+	max_views > 0
+RETURNING
+	max_views
+`
+
+var selectBlockedHashes = `
+SELECT
+	hash,
+	reason
+FROM
+	blocked_hashes
+`
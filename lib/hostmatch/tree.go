@@ -0,0 +1,85 @@
+package hostmatch
+
+import "strings"
+
+// Tree matches domain names against a list of patterns, where each label of a
+// pattern may be a "*" wildcard matching exactly one label (e.g. "*.example.com"
+// matches "cdn.example.com" but not "example.com"). Patterns are tried in the
+// order they were provided.
+type Tree struct {
+	Leaf      bool
+	Value     string
+	FullValue string
+	SubNodes  []*Tree
+}
+
+func (t *Tree) findOrCreateSubNode(v string) *Tree {
+	if t.SubNodes == nil {
+		t.SubNodes = []*Tree{}
+	}
+
+	for _, n := range t.SubNodes {
+		if !n.Leaf && n.Value == v {
+			return n
+		}
+	}
+	node := &Tree{Value: v}
+	t.SubNodes = append(t.SubNodes, node)
+	return node
+}
+
+// GetMatch returns the pattern matching a domain name split into labels, or an
+// empty string if no pattern matches. A pattern only matches if it has the same
+// number of labels as the domain name.
+func (t *Tree) GetMatch(s []string) string {
+	if t.Leaf || len(t.SubNodes) == 0 || len(s) == 0 {
+		return ""
+	}
+
+	for _, node := range t.SubNodes {
+		if node.Value == "*" || node.Value == s[0] {
+			if node.Leaf {
+				if len(s) == 1 {
+					return node.FullValue
+				}
+				continue
+			}
+			if match := node.GetMatch(s[1:]); match != "" {
+				return match
+			}
+		}
+	}
+
+	return ""
+}
+
+// Match returns the pattern matching a domain name, or an empty string if no
+// pattern matches. Matching is case-insensitive.
+func (t *Tree) Match(hostname string) string {
+	return t.GetMatch(strings.Split(strings.ToLower(hostname), "."))
+}
+
+// Parse creates a *Tree from a list of patterns.
+func Parse(patterns []string) *Tree {
+	// NOTE: using arrays because the provided order is important (maps would be a check if part in in map,
+	// then check for *, which works but doesn't maintain order)
+	tree := &Tree{SubNodes: []*Tree{}}
+	for _, d := range patterns {
+		split := strings.Split(d, ".")
+
+		currentNode := tree
+		for i, s := range split {
+			currentNode = currentNode.findOrCreateSubNode(s)
+
+			if i+1 == len(split) {
+				currentNode.Leaf = true
+				currentNode.FullValue = d
+				continue
+			}
+			if currentNode.SubNodes == nil {
+				currentNode.SubNodes = []*Tree{}
+			}
+		}
+	}
+	return tree
+}
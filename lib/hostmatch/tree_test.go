@@ -0,0 +1,71 @@
+package hostmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tree := Parse([]string{
+		"example.com",
+		"*.example.com",
+		"cdn.example.net",
+		"example.net",
+		"*.*.example.org",
+		"static.*",
+	})
+	tests := []struct {
+		host string
+		want string
+	}{
+		// exact
+		{"example.com", "example.com"},
+		{"EXAMPLE.com", "example.com"},
+		{"example.net", "example.net"},
+		{"cdn.example.net", "cdn.example.net"},
+
+		// pattern followed by extra labels
+		{"example.com.attacker.net", ""},
+		{"example.net.attacker.net", ""},
+		{"cdn.example.net.attacker.net", ""},
+		{"a.example.com.attacker.net", ""},
+		{"example.comattacker.net", ""},
+
+		// pattern preceded by extra labels
+		{"attacker.example.net", ""},
+		{"attackerexample.com", ""},
+		{"a.b.example.com", ""},
+
+		// wildcards
+		{"cdn.example.com", "*.example.com"},
+		{"a.b.example.org", "*.*.example.org"},
+		{"a.example.org", ""},
+		{"static.example", "static.*"},
+		{"static.example.net", ""},
+		{"static", ""},
+
+		// no match
+		{"", ""},
+		{"com", ""},
+		{"example.org", ""},
+	}
+	for _, tt := range tests {
+		if got := tree.Match(tt.host); got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		host     string
+		want     string
+	}{
+		{[]string{"*.example.com", "cdn.example.com"}, "cdn.example.com", "*.example.com"},
+		{[]string{"cdn.example.com", "*.example.com"}, "cdn.example.com", "cdn.example.com"},
+		{[]string{"example.com", "cdn.example.com.au"}, "cdn.example.com.au", "cdn.example.com.au"},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.patterns).Match(tt.host); got != tt.want {
+			t.Errorf("Parse(%q).Match(%q) = %q, want %q", tt.patterns, tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package hotlink
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"owo.codes/whats-this/cdn-origin/lib/hostmatch"
+)
+
+// Action is the action taken when a request is blocked.
+type Action int
+
+const (
+	// Forbidden responds with 403 Forbidden.
+	Forbidden Action = iota
+
+	// Placeholder responds with a placeholder image.
+	Placeholder
+
+	// Preview redirects to the HTML preview page of the object.
+	Preview
+)
+
+// ParseAction parses an action name from configuration ("forbidden",
+// "placeholder" or "preview").
+func ParseAction(name string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "forbidden", "403":
+		return Forbidden, nil
+	case "placeholder", "image":
+		return Placeholder, nil
+	case "preview", "redirect":
+		return Preview, nil
+	}
+	return Forbidden, fmt.Errorf("unknown hotlink action %q", name)
+}
+
+// Rule is a hotlink protection rule from configuration. A rule applies to
+// requests for objects in Bucket (any bucket if empty) on one of Hosts (any
+// host if empty). Referring hosts matching Deny are blocked; if Allow is not
+// empty, referring hosts not matching it are blocked as well. Host patterns
+// may use "*" wildcard labels.
+type Rule struct {
+	Bucket            string   `mapstructure:"bucket"`
+	Hosts             []string `mapstructure:"hosts"`
+	Allow             []string `mapstructure:"allow"`
+	Deny              []string `mapstructure:"deny"`
+	Action            string   `mapstructure:"action"`
+	AllowEmptyReferer *bool    `mapstructure:"allowEmptyReferer"`
+}
+
+// rule is a parsed Rule.
+type rule struct {
+	bucket     string
+	hosts      *hostmatch.Tree
+	allow      *hostmatch.Tree
+	deny       *hostmatch.Tree
+	action     Action
+	allowEmpty bool
+}
+
+// Policy decides whether requests are allowed based on their Referer and
+// Origin headers.
+type Policy struct {
+	rules []rule
+}
+
+// New creates a new *Policy. Rules are evaluated in order and the first rule
+// matching the bucket and host is used. action and allowEmptyReferer are the
+// defaults for rules which don't set them.
+func New(rules []Rule, action Action, allowEmptyReferer bool) (*Policy, error) {
+	p := &Policy{rules: make([]rule, len(rules))}
+	for i, r := range rules {
+		parsed := rule{
+			bucket:     r.Bucket,
+			action:     action,
+			allowEmpty: allowEmptyReferer,
+		}
+		if len(r.Hosts) != 0 {
+			parsed.hosts = hostmatch.Parse(lowerAll(r.Hosts))
+		}
+		if len(r.Allow) != 0 {
+			parsed.allow = hostmatch.Parse(lowerAll(r.Allow))
+		}
+		if len(r.Deny) != 0 {
+			parsed.deny = hostmatch.Parse(lowerAll(r.Deny))
+		}
+		if r.Action != "" {
+			a, err := ParseAction(r.Action)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %s", i, err)
+			}
+			parsed.action = a
+		}
+		if r.AllowEmptyReferer != nil {
+			parsed.allowEmpty = *r.AllowEmptyReferer
+		}
+		p.rules[i] = parsed
+	}
+	return p, nil
+}
+
+// Check evaluates the policy for a request for an object in bucket on host,
+// with the given Referer and Origin header values. applies is false if no rule
+// matches the bucket and host. Requests referred by host itself are always
+// allowed.
+func (p *Policy) Check(bucket, host, referer, origin string) (allowed bool, action Action, applies bool) {
+	host = stripPort(strings.ToLower(host))
+	for _, r := range p.rules {
+		if r.bucket != "" && r.bucket != bucket {
+			continue
+		}
+		if r.hosts != nil && r.hosts.Match(host) == "" {
+			continue
+		}
+		return r.allowed(host, refererHost(referer, origin)), r.action, true
+	}
+	return true, Forbidden, false
+}
+
+// allowed returns true if r allows requests on host referred by refHost.
+func (r *rule) allowed(host, refHost string) bool {
+	switch {
+	case refHost == "":
+		return r.allowEmpty
+	case refHost == host:
+		return true
+	case r.deny != nil && r.deny.Match(refHost) != "":
+		return false
+	case r.allow != nil:
+		return r.allow.Match(refHost) != ""
+	}
+	return true
+}
+
+// refererHost returns the lowercase hostname of the Referer header, falling
+// back to the Origin header. An empty string is returned if neither is set or
+// parseable, or if the origin is opaque ("null").
+func refererHost(referer, origin string) string {
+	for _, v := range []string{referer, origin} {
+		if v == "" || v == "null" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		return strings.ToLower(u.Hostname())
+	}
+	return ""
+}
+
+// stripPort removes the port from a host header value.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// lowerAll returns a copy of s with all strings lowercased.
+func lowerAll(s []string) []string {
+	lower := make([]string, len(s))
+	for i, v := range s {
+		lower[i] = strings.ToLower(v)
+	}
+	return lower
+}
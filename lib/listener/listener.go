@@ -0,0 +1,141 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// unixPrefix prefixes Unix domain socket addresses.
+	unixPrefix = "unix:"
+
+	// systemdPrefix prefixes systemd socket-activation addresses.
+	systemdPrefix = "systemd:"
+
+	// systemdFirstFD is the first file descriptor passed by systemd.
+	systemdFirstFD = 3
+)
+
+// Listen creates a listener for an address, which is one of:
+//
+//   - "unix:/path/to/socket": a Unix domain socket with the permissions
+//     unixMode, replacing any existing socket file
+//   - "systemd:<name>" or "systemd:<index>": a listener inherited through
+//     systemd socket activation (LISTEN_FDS), selected by its FileDescriptorName
+//     or its zero-based index
+//   - any other value: a TCP (IPv4) address
+func Listen(address string, unixMode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		return listenUnix(strings.TrimPrefix(address, unixPrefix), unixMode)
+	case strings.HasPrefix(address, systemdPrefix):
+		return systemdListener(strings.TrimPrefix(address, systemdPrefix))
+	}
+	return net.Listen("tcp4", address)
+}
+
+// listenUnix listens on a Unix domain socket.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("refusing to replace %s, it is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove existing socket %s: %s", path, err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set permissions of socket %s: %s", path, err)
+	}
+	return ln, nil
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdListeners []net.Listener
+	systemdNames     []string
+	systemdErr       error
+)
+
+// systemdListener returns an inherited systemd listener by name or index.
+func systemdListener(selector string) (net.Listener, error) {
+	systemdOnce.Do(loadSystemdListeners)
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+	for i, name := range systemdNames {
+		if name == selector && systemdListeners[i] != nil {
+			return systemdListeners[i], nil
+		}
+	}
+	if i, err := strconv.Atoi(selector); err == nil && i >= 0 && i < len(systemdListeners) &&
+		systemdListeners[i] != nil {
+		return systemdListeners[i], nil
+	}
+	return nil, fmt.Errorf("no inherited systemd listener %q", selector)
+}
+
+// loadSystemdListeners creates listeners from the file descriptors passed by
+// systemd, as described in sd_listen_fds(3).
+func loadSystemdListeners() {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		systemdErr = fmt.Errorf("no listeners were passed by systemd")
+		return
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		systemdErr = fmt.Errorf("no listeners were passed by systemd")
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	systemdListeners = make([]net.Listener, count)
+	systemdNames = make([]string, count)
+	for i := 0; i < count; i++ {
+		if i < len(names) {
+			systemdNames[i] = names[i]
+		}
+		file := os.NewFile(uintptr(systemdFirstFD+i), "systemd:"+systemdNames[i])
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			// Not a listening socket (such as a datagram socket)
+			continue
+		}
+		systemdListeners[i] = ln
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+}
+
+// ParseMode parses an octal file mode, such as "0660".
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// PeerIP returns the IP address of a connection's remote address. Peers on
+// Unix domain sockets are local, so they are reported as 127.0.0.1.
+func PeerIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UnixAddr:
+		return net.IPv4(127, 0, 0, 1)
+	}
+	return net.IPv4zero
+}
@@ -0,0 +1,96 @@
+package listener
+
+import (
+	"net"
+	"sync"
+)
+
+// acceptResult is a connection or error returned by an underlying listener.
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// Multi merges multiple listeners into one, so a single server can accept
+// connections from all of them. The first listener's address is reported by
+// Addr.
+type Multi struct {
+	listeners []net.Listener
+	results   chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMulti creates a new *Multi and starts accepting connections from all
+// listeners.
+func NewMulti(listeners ...net.Listener) *Multi {
+	m := &Multi{
+		listeners: listeners,
+		results:   make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, ln := range listeners {
+		go m.accept(ln)
+	}
+	return m
+}
+
+// accept forwards connections from a listener until it fails permanently or
+// m is closed.
+func (m *Multi) accept(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		select {
+		case m.results <- acceptResult{conn: c, err: err}:
+		case <-m.done:
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+	}
+}
+
+// Accept implements net.Listener.
+func (m *Multi) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.results:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, errClosed
+	}
+}
+
+// Close implements net.Listener and closes all underlying listeners.
+func (m *Multi) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, ln := range m.listeners {
+			if e := ln.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr implements net.Listener.
+func (m *Multi) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
+
+// closedError is returned by Accept after the listener is closed.
+type closedError struct{}
+
+func (closedError) Error() string   { return "listener closed" }
+func (closedError) Timeout() bool   { return false }
+func (closedError) Temporary() bool { return false }
+
+var errClosed net.Error = closedError{}
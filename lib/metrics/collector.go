@@ -7,6 +7,8 @@ import (
 	"net"
 	"strings"
 
+	"owo.codes/whats-this/cdn-origin/lib/hostmatch"
+
 	"github.com/oschwald/maxminddb-golang"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/olivere/elastic.v5"
@@ -84,7 +86,7 @@ type Collector struct {
 	geoIPDatabase *maxminddb.Reader
 
 	enableHostnameWhitelist bool
-	hostnameWhitelist       *treeNode
+	hostnameWhitelist       *hostmatch.Tree
 }
 
 // New creates a new Elasticsearch connection and returns a Collector using that connection.
@@ -159,10 +161,10 @@ func New(elasticURL string, maxmindLoc string, enableHostnameWhitelist bool, hos
 		}
 	}
 
-	// Construct hostname whitelist *treeNode
-	var hostnameWhitelistTree *treeNode
+	// Construct hostname whitelist *hostmatch.Tree
+	var hostnameWhitelistTree *hostmatch.Tree
 	if enableHostnameWhitelist {
-		hostnameWhitelistTree = parseWhitelistSlice(hostnameWhitelist)
+		hostnameWhitelistTree = hostmatch.Parse(hostnameWhitelist)
 	}
 
 	// Create Collector
@@ -197,7 +199,7 @@ func (c *Collector) MatchHostname(hostname string) (string, bool) {
 		if hostSplit[0] == "www" {
 			hostSplit = hostSplit[1:]
 		}
-		if match := c.hostnameWhitelist.getMatch(hostSplit); match != "" {
+		if match := c.hostnameWhitelist.GetMatch(hostSplit); match != "" {
 			if strings.HasPrefix(match, "*.") {
 				hostSplit[0] = "*"
 			}
@@ -209,6 +211,19 @@ func (c *Collector) MatchHostname(hostname string) (string, bool) {
 	return hostname, true
 }
 
+// Health checks the health of the Elasticsearch cluster. An error is returned if
+// the cluster can't be reached or its status is red.
+func (c *Collector) Health(ctx context.Context) error {
+	health, err := c.elastic.ClusterHealth().Index(c.index).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Elasticsearch cluster health: %s", err)
+	}
+	if health.Status == "red" {
+		return errors.New("Elasticsearch cluster health is red")
+	}
+	return nil
+}
+
 // GetCountryCode returns the country code for an IP address from the MaxMind GeoLite2 Country database.
 func (c *Collector) GetCountryCode(ip net.IP) (string, error) {
 	if c.geoIPDatabase == nil {
@@ -0,0 +1,130 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// v1Prefix starts every PROXY protocol v1 header.
+	v1Prefix = []byte("PROXY ")
+
+	// v2Signature starts every PROXY protocol v2 header.
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1MaxLength is the maximum length of a v1 header, including the CRLF.
+const v1MaxLength = 107
+
+// ErrInvalidHeader means a connection sent an invalid PROXY header.
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// readV1 reads a PROXY protocol v1 (text) header. If the connection doesn't
+// start with a v1 header, nothing is read and nil addresses are returned.
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	prefix, err := r.Peek(len(v1Prefix))
+	if err != nil || !bytes.Equal(prefix, v1Prefix) {
+		return nil, nil, nil
+	}
+
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidHeader
+	}
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr.IP == nil || err != nil {
+		return nil, ErrInvalidHeader
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+// readV2 reads a PROXY protocol v2 (binary) header. If the connection doesn't
+// start with a v2 header, nothing is read and nil addresses are returned.
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	signature, err := r.Peek(len(v2Signature))
+	if err != nil || !bytes.Equal(signature, v2Signature) {
+		return nil, nil, nil
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, nil, ErrInvalidHeader
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections (such as health checks) keep their own addresses
+	if verCmd&0x0f == 0 {
+		return nil, nil, nil
+	}
+	if verCmd&0x0f != 1 {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	var ipLength int
+	switch family >> 4 {
+	case 1: // AF_INET
+		ipLength = net.IPv4len
+	case 2: // AF_INET6
+		ipLength = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil, nil
+	}
+	if len(payload) < ipLength*2+4 {
+		return nil, nil, ErrInvalidHeader
+	}
+	src := &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), payload[:ipLength]...)),
+		Port: int(binary.BigEndian.Uint16(payload[ipLength*2:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), payload[ipLength:ipLength*2]...)),
+		Port: int(binary.BigEndian.Uint16(payload[ipLength*2+2:])),
+	}
+	return src, dst, nil
+}
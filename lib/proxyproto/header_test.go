@@ -0,0 +1,209 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// v2Header builds a PROXY protocol v2 header.
+func v2Header(verCmd, family byte, payload []byte) []byte {
+	header := append([]byte(nil), v2Signature...)
+	header = append(header, verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// v2Payload builds the address payload of a v2 header.
+func v2Payload(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	payload := append(append([]byte(nil), src...), dst...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, srcPort)
+	binary.BigEndian.PutUint16(ports[2:], dstPort)
+	return append(payload, ports...)
+}
+
+func TestReadV1(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantSrc  string
+		wantDst  string
+		wantErr  bool
+		wantRest string
+	}{
+		{
+			name:     "tcp4",
+			input:    "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n",
+			wantSrc:  "192.0.2.1:56324",
+			wantDst:  "198.51.100.1:443",
+			wantRest: "GET / HTTP/1.1\r\n",
+		},
+		{
+			name:     "tcp6",
+			input:    "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET",
+			wantSrc:  "[2001:db8::1]:56324",
+			wantDst:  "[2001:db8::2]:443",
+			wantRest: "GET",
+		},
+		{
+			name:     "unknown",
+			input:    "PROXY UNKNOWN\r\nGET",
+			wantRest: "GET",
+		},
+		{
+			name:     "no header",
+			input:    "GET / HTTP/1.1\r\n",
+			wantRest: "GET / HTTP/1.1\r\n",
+		},
+		{
+			name:    "missing CRLF",
+			input:   "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n",
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			input:   "PROXY TCP4 " + string(bytes.Repeat([]byte("1"), v1MaxLength)) + "\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad protocol",
+			input:   "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad address",
+			input:   "PROXY TCP4 192.0.2.999 198.51.100.1 56324 443\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad port",
+			input:   "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing fields",
+			input:   "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewBufferString(tt.input))
+			src, dst, err := readV1(r)
+			checkHeader(t, r, src, dst, err, tt.wantSrc, tt.wantDst, tt.wantErr, tt.wantRest)
+		})
+	}
+}
+
+func TestReadV2(t *testing.T) {
+	ip4Payload := v2Payload(net.IPv4(192, 0, 2, 1).To4(), net.IPv4(198, 51, 100, 1).To4(), 56324, 443)
+	ip6Payload := v2Payload(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 56324, 443)
+	tests := []struct {
+		name     string
+		input    []byte
+		wantSrc  string
+		wantDst  string
+		wantErr  bool
+		wantRest string
+	}{
+		{
+			name:     "tcp4",
+			input:    append(v2Header(0x21, 0x11, ip4Payload), "GET"...),
+			wantSrc:  "192.0.2.1:56324",
+			wantDst:  "198.51.100.1:443",
+			wantRest: "GET",
+		},
+		{
+			name:     "tcp6",
+			input:    append(v2Header(0x21, 0x21, ip6Payload), "GET"...),
+			wantSrc:  "[2001:db8::1]:56324",
+			wantDst:  "[2001:db8::2]:443",
+			wantRest: "GET",
+		},
+		{
+			name:     "tlvs are skipped",
+			input:    append(v2Header(0x21, 0x11, append(ip4Payload, 0x04, 0x00, 0x01, 0xff)), "GET"...),
+			wantSrc:  "192.0.2.1:56324",
+			wantDst:  "198.51.100.1:443",
+			wantRest: "GET",
+		},
+		{
+			name:     "local",
+			input:    append(v2Header(0x20, 0x11, ip4Payload), "GET"...),
+			wantRest: "GET",
+		},
+		{
+			name:     "unspec family",
+			input:    append(v2Header(0x21, 0x00, nil), "GET"...),
+			wantRest: "GET",
+		},
+		{
+			name:     "no header",
+			input:    []byte("GET / HTTP/1.1\r\n"),
+			wantRest: "GET / HTTP/1.1\r\n",
+		},
+		{
+			name:    "bad version",
+			input:   v2Header(0x11, 0x11, ip4Payload),
+			wantErr: true,
+		},
+		{
+			name:    "bad command",
+			input:   v2Header(0x22, 0x11, ip4Payload),
+			wantErr: true,
+		},
+		{
+			name:    "short payload",
+			input:   v2Header(0x21, 0x21, ip4Payload),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			input:   v2Header(0x21, 0x11, ip4Payload)[:20],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.input))
+			src, dst, err := readV2(r)
+			checkHeader(t, r, src, dst, err, tt.wantSrc, tt.wantDst, tt.wantErr, tt.wantRest)
+		})
+	}
+}
+
+// checkHeader checks the result of reading a header and the data left after
+// it.
+func checkHeader(t *testing.T, r *bufio.Reader, src, dst net.Addr, err error, wantSrc, wantDst string, wantErr bool, wantRest string) {
+	t.Helper()
+	if wantErr {
+		if err == nil {
+			t.Fatalf("err = nil, want error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if got := addrString(src); got != wantSrc {
+		t.Errorf("src = %q, want %q", got, wantSrc)
+	}
+	if got := addrString(dst); got != wantDst {
+		t.Errorf("dst = %q, want %q", got, wantDst)
+	}
+	rest, _ := ioutil.ReadAll(r)
+	if string(rest) != wantRest {
+		t.Errorf("remaining data = %q, want %q", rest, wantRest)
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
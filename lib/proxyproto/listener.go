@@ -0,0 +1,153 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"owo.codes/whats-this/cdn-origin/lib/clientip"
+	"owo.codes/whats-this/cdn-origin/lib/listener"
+)
+
+// Listener wraps a net.Listener and parses PROXY protocol (v1 and v2) headers
+// sent by allowed sources, so connections report the original client address.
+type Listener struct {
+	net.Listener
+
+	allowed       []*net.IPNet
+	headerTimeout time.Duration
+}
+
+// NewListener creates a new *Listener. PROXY headers are only parsed from
+// connections with a source address in allowed (Unix socket peers have the
+// address 127.0.0.1), other connections are passed through unchanged. The
+// header must be received within headerTimeout.
+func NewListener(ln net.Listener, allowed []*net.IPNet, headerTimeout time.Duration) *Listener {
+	return &Listener{
+		Listener:      ln,
+		allowed:       allowed,
+		headerTimeout: headerTimeout,
+	}
+}
+
+// Accept implements net.Listener. The PROXY header is parsed lazily on the
+// first call to Read or RemoteAddr, so a slow client can't block the accept
+// loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !clientip.Contains(l.allowed, listener.PeerIP(c.RemoteAddr())) {
+		return c, nil
+	}
+	return &Conn{
+		Conn:          c,
+		r:             bufio.NewReader(c),
+		headerTimeout: l.headerTimeout,
+	}, nil
+}
+
+// Conn is a connection from an allowed source which may start with a PROXY
+// header.
+type Conn struct {
+	net.Conn
+
+	r             *bufio.Reader
+	headerTimeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	err        error
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Read implements net.Conn. If the PROXY header is invalid, an error is
+// returned.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// ReadFrom implements io.ReaderFrom using the underlying connection's
+// ReadFrom if it has one, so files can still be sent with sendfile(2).
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{c.Conn}, r)
+}
+
+// writerOnly hides the ReadFrom method of a writer from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// RemoteAddr implements net.Conn, returning the source address from the PROXY
+// header if one was sent.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr implements net.Conn, returning the destination address from the
+// PROXY header if one was sent.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads the PROXY header, if any. The read deadline set by the user
+// of the connection is restored afterwards.
+func (c *Conn) readHeader() {
+	if c.headerTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.Conn.SetReadDeadline(c.readDeadline)
+		}()
+	}
+
+	first, err := c.r.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		c.remoteAddr, c.localAddr, c.err = readV1(c.r)
+	case v2Signature[0]:
+		c.remoteAddr, c.localAddr, c.err = readV2(c.r)
+	}
+}
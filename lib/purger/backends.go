@@ -0,0 +1,175 @@
+package purger
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+// Backend purges URLs from a CDN.
+type Backend interface {
+	Purge(urls []string) error
+}
+
+// cloudflareBatchSize is the maximum amount of URLs in a single Cloudflare
+// purge request.
+const cloudflareBatchSize = 30
+
+// DefaultTimeout is the timeout of each purge request if a backend has none.
+const DefaultTimeout = 10 * time.Second
+
+// CloudflareBackend purges URLs using the Cloudflare purge_cache API, or any
+// API accepting the same JSON body.
+type CloudflareBackend struct {
+	// Endpoint is the purge URL, such as
+	// https://api.cloudflare.com/client/v4/zones/<zone ID>/purge_cache.
+	Endpoint string
+
+	// Token is the API token sent as a bearer token.
+	Token string
+
+	// Timeout is the timeout of each request, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// Purge implements Backend.
+func (b *CloudflareBackend) Purge(urls []string) error {
+	for len(urls) > 0 {
+		n := len(urls)
+		if n > cloudflareBatchSize {
+			n = cloudflareBatchSize
+		}
+		body, err := json.Marshal(map[string][]string{"files": urls[:n]})
+		if err != nil {
+			return errors.Wrap(err, "failed to encode purge request")
+		}
+		err = doRequest("POST", b.Endpoint, "application/json", "Bearer "+b.Token, body, b.Timeout)
+		if err != nil {
+			return err
+		}
+		urls = urls[n:]
+	}
+	return nil
+}
+
+// HTTPBackend purges URLs by sending requests built from templates. The
+// templates are executed with a templateData value.
+type HTTPBackend struct {
+	// Method is the request method, such as POST or PURGE.
+	Method string
+
+	// URL is the request URL template.
+	URL *template.Template
+
+	// Body is the request body template, and may be nil.
+	Body *template.Template
+
+	// ContentType is the request Content-Type, only sent with a body.
+	ContentType string
+
+	// Authorization is the value of the Authorization header, omitted if empty.
+	Authorization string
+
+	// PerURL sends a request for each URL instead of a single request for all
+	// URLs.
+	PerURL bool
+
+	// Timeout is the timeout of each request, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// templateData is passed to HTTPBackend templates. URL is only set if
+// HTTPBackend.PerURL is true.
+type templateData struct {
+	URL  string
+	URLs []string
+}
+
+// Purge implements Backend.
+func (b *HTTPBackend) Purge(urls []string) error {
+	if !b.PerURL {
+		return b.do(templateData{URLs: urls})
+	}
+	for _, u := range urls {
+		if err := b.do(templateData{URL: u, URLs: []string{u}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *HTTPBackend) do(data templateData) error {
+	var uri bytes.Buffer
+	if err := b.URL.Execute(&uri, data); err != nil {
+		return errors.Wrap(err, "failed to execute URL template")
+	}
+	var body []byte
+	if b.Body != nil {
+		var buf bytes.Buffer
+		if err := b.Body.Execute(&buf, data); err != nil {
+			return errors.Wrap(err, "failed to execute body template")
+		}
+		body = buf.Bytes()
+	}
+	return doRequest(b.Method, uri.String(), b.ContentType, b.Authorization, body, b.Timeout)
+}
+
+// MemoryBackend records purged URLs in memory instead of purging them. It is a
+// local stand-in for a CDN when testing.
+type MemoryBackend struct {
+	mu     sync.Mutex
+	purged []string
+}
+
+// Purge implements Backend.
+func (b *MemoryBackend) Purge(urls []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.purged = append(b.purged, urls...)
+	return nil
+}
+
+// Purged returns all URLs purged so far.
+func (b *MemoryBackend) Purged() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.purged...)
+}
+
+// doRequest sends a purge request and checks for a 2xx response. Requests
+// taking longer than timeout fail, so a hung API can't stall the purge queue.
+func doRequest(method, uri, contentType, authorization string, body []byte, timeout time.Duration) error {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}()
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if body != nil {
+		req.Header.SetContentType(contentType)
+		req.SetBody(body)
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	err := fasthttp.DoTimeout(req, res, timeout)
+	if err != nil {
+		return errors.Wrap(err, "failed to make purge request")
+	}
+	if res.StatusCode() < 200 || res.StatusCode() > 299 {
+		return errors.Errorf("purge request failed with status code %d: %s", res.StatusCode(), string(res.Body()))
+	}
+	return nil
+}
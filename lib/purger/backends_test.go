@@ -0,0 +1,64 @@
+package purger
+
+import (
+	"net"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// hangingServer accepts connections but never responds.
+func hangingServer(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+	return "http://" + ln.Addr().String() + "/purge", func() {
+		ln.Close()
+		<-done
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+}
+
+func TestBackendTimeout(t *testing.T) {
+	endpoint, stop := hangingServer(t)
+	defer stop()
+
+	tests := []struct {
+		name    string
+		backend Backend
+	}{
+		{"cloudflare", &CloudflareBackend{Endpoint: endpoint, Timeout: 50 * time.Millisecond}},
+		{"http", &HTTPBackend{
+			Method:  "PURGE",
+			URL:     template.Must(template.New("url").Parse(endpoint)),
+			Timeout: 50 * time.Millisecond,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.backend, []string{"https://example.com"}, []string{""}, 2, time.Millisecond, 0)
+			start := time.Now()
+			if err := p.Purge("/file.png"); err == nil {
+				t.Fatal("Purge() error = nil, want timeout error")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Purge() took %s, want timeouts to fail quickly", elapsed)
+			}
+		})
+	}
+}
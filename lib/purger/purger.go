@@ -0,0 +1,100 @@
+package purger
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultVariants are the query strings of the URL variants purged for each
+// object.
+var DefaultVariants = []string{"", "?thumbnail", "?_raw=true", "?preview", "?info", "?download"}
+
+// Purger purges all URL variants of objects from a CDN when they change.
+type Purger struct {
+	backend     Backend
+	baseURLs    []string
+	variants    []string
+	maxAttempts int
+	retryDelay  time.Duration
+	queue       chan string
+}
+
+// New creates a new *Purger and starts its queue worker. URLs are built from
+// each base URL (scheme and host, such as https://example.com), the object key
+// and each variant. Failed purges are attempted up to maxAttempts times, with
+// the delay between attempts starting at retryDelay and doubling after each
+// attempt.
+func New(backend Backend, baseURLs, variants []string, maxAttempts int, retryDelay time.Duration, queueSize int) *Purger {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	p := &Purger{
+		backend:     backend,
+		baseURLs:    make([]string, len(baseURLs)),
+		variants:    variants,
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+		queue:       make(chan string, queueSize),
+	}
+	for i, u := range baseURLs {
+		p.baseURLs[i] = strings.TrimRight(u, "/")
+	}
+	go p.work()
+	return p
+}
+
+// URLs returns all URL variants of an object key.
+func (p *Purger) URLs(key string) []string {
+	path := (&url.URL{Path: "/" + strings.TrimPrefix(key, "/")}).EscapedPath()
+	urls := make([]string, 0, len(p.baseURLs)*len(p.variants))
+	for _, base := range p.baseURLs {
+		for _, variant := range p.variants {
+			urls = append(urls, base+path+variant)
+		}
+	}
+	return urls
+}
+
+// Purge purges all URL variants of an object key, retrying on failure.
+func (p *Purger) Purge(key string) error {
+	urls := p.URLs(key)
+	delay := p.retryDelay
+	var err error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		err = p.backend.Purge(urls)
+		if err == nil {
+			return nil
+		}
+		if attempt < p.maxAttempts {
+			log.Debug().Err(err).Str("key", key).Int("attempt", attempt).Msg("failed to purge object, retrying")
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// Enqueue queues an object key to be purged in the background. If the queue is
+// full, false is returned and the key is not purged.
+func (p *Purger) Enqueue(key string) bool {
+	select {
+	case p.queue <- key:
+		return true
+	default:
+		return false
+	}
+}
+
+// work purges queued object keys.
+func (p *Purger) work() {
+	for key := range p.queue {
+		if err := p.Purge(key); err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("failed to purge object")
+			continue
+		}
+		log.Debug().Str("key", key).Msg("purged object")
+	}
+}
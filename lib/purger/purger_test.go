@@ -0,0 +1,138 @@
+package purger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails a number of times before passing purges to a
+// MemoryBackend.
+type flakyBackend struct {
+	MemoryBackend
+	failures int
+	attempts int
+}
+
+func (b *flakyBackend) Purge(urls []string) error {
+	b.attempts++
+	if b.attempts <= b.failures {
+		return errors.New("purge failed")
+	}
+	return b.MemoryBackend.Purge(urls)
+}
+
+// blockingBackend blocks purges until release is closed.
+type blockingBackend struct {
+	MemoryBackend
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) Purge(urls []string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.MemoryBackend.Purge(urls)
+}
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURLs []string
+		variants []string
+		key      string
+		want     []string
+	}{
+		{
+			name:     "single base",
+			baseURLs: []string{"https://example.com"},
+			variants: []string{"", "?thumbnail"},
+			key:      "/file.png",
+			want:     []string{"https://example.com/file.png", "https://example.com/file.png?thumbnail"},
+		},
+		{
+			name:     "trailing slash and missing leading slash",
+			baseURLs: []string{"https://example.com/", "https://cdn.example.com"},
+			variants: []string{""},
+			key:      "dir/file.png",
+			want:     []string{"https://example.com/dir/file.png", "https://cdn.example.com/dir/file.png"},
+		},
+		{
+			name:     "escaped path",
+			baseURLs: []string{"https://example.com"},
+			variants: []string{"?info"},
+			key:      "/a file?.txt",
+			want:     []string{"https://example.com/a%20file%3F.txt?info"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(&MemoryBackend{}, tt.baseURLs, tt.variants, 1, 0, 0)
+			if got := p.URLs(tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("URLs(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		maxAttempts  int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"success", 0, 3, false, 1},
+		{"success after retries", 2, 3, false, 3},
+		{"out of attempts", 3, 3, true, 3},
+		{"single attempt", 1, 0, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &flakyBackend{failures: tt.failures}
+			p := New(b, []string{"https://example.com"}, []string{""}, tt.maxAttempts, time.Millisecond, 0)
+			err := p.Purge("/file.png")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Purge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if b.attempts != tt.wantAttempts {
+				t.Errorf("Purge() made %d attempts, want %d", b.attempts, tt.wantAttempts)
+			}
+			wantPurged := 0
+			if !tt.wantErr {
+				wantPurged = 1
+			}
+			if got := len(b.Purged()); got != wantPurged {
+				t.Errorf("Purge() purged %d URLs, want %d", got, wantPurged)
+			}
+		})
+	}
+}
+
+func TestEnqueueFull(t *testing.T) {
+	b := &blockingBackend{started: make(chan struct{}), release: make(chan struct{})}
+	p := New(b, []string{"https://example.com"}, []string{""}, 1, 0, 1)
+
+	if !p.Enqueue("/a") {
+		t.Fatal("Enqueue(/a) = false, want true")
+	}
+	<-b.started
+	if !p.Enqueue("/b") {
+		t.Fatal("Enqueue(/b) = false, want true")
+	}
+	if p.Enqueue("/c") {
+		t.Fatal("Enqueue(/c) = true, want false with a full queue")
+	}
+
+	close(b.release)
+	<-b.started
+	want := []string{"https://example.com/a", "https://example.com/b"}
+	for i := 0; i < 100 && len(b.Purged()) < len(want); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if got := b.Purged(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Purged() = %q, want %q", got, want)
+	}
+}
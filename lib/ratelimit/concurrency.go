@@ -0,0 +1,43 @@
+package ratelimit
+
+import "sync"
+
+// Concurrency caps the number of concurrently held slots (such as open
+// connections) per key.
+type Concurrency struct {
+	max int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// NewConcurrency creates a new *Concurrency allowing up to max slots per key.
+func NewConcurrency(max int) *Concurrency {
+	return &Concurrency{
+		max:    max,
+		active: make(map[string]int),
+	}
+}
+
+// Acquire reserves a slot for key, returning false if key is at its cap. Every
+// successful Acquire must be followed by a Release.
+func (c *Concurrency) Acquire(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[key] >= c.max {
+		return false
+	}
+	c.active[key]++
+	return true
+}
+
+// Release frees a slot reserved by Acquire.
+func (c *Concurrency) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[key] <= 1 {
+		delete(c.active, key)
+		return
+	}
+	c.active[key]--
+}
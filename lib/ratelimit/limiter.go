@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets keyed by client. Each bucket holds up to
+// burst tokens and is refilled at rate tokens per second; every request takes
+// one token.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket is the state of a single client's token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a new *Limiter allowing rate requests per second with bursts of
+// up to burst requests.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket. If the bucket is empty, it returns
+// false and the time until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep removes buckets which have been full for a while, so the map doesn't
+// grow with every client ever seen. Sweeps run at most once a minute.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Key returns the rate limiting key for an IP address. Addresses are grouped by
+// subnet when v4Bits or v6Bits are below the full address length, so clients
+// can't evade limits by rotating through addresses in their allocation.
+func Key(ip net.IP, v4Bits, v6Bits int) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		if v4Bits > 0 && v4Bits < 32 {
+			return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(v4Bits, 32)), Mask: net.CIDRMask(v4Bits, 32)}).String()
+		}
+		return ip4.String()
+	}
+	if v6Bits > 0 && v6Bits < 128 {
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(v6Bits, 128)), Mask: net.CIDRMask(v6Bits, 128)}).String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,90 @@
+package redact
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Placeholder replaces redacted values.
+const Placeholder = "[redacted]"
+
+// SecretKeys are substrings of configuration keys whose values are redacted.
+var SecretKeys = []string{"authorization", "connectionurl", "password", "secret", "token"}
+
+// headerMapKey is a substring of configuration keys whose values are maps of
+// HTTP headers. All values in header maps are redacted, as they often contain
+// API keys under arbitrary header names.
+const headerMapKey = "headers"
+
+// Config returns a copy of a configuration map (as returned by
+// viper.AllSettings) with secrets removed. Values of keys matching SecretKeys
+// and all values in header maps are replaced with Placeholder, and userinfo is
+// stripped from URL values.
+func Config(config map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		lowerKey := strings.ToLower(k)
+		if m, ok := v.(map[string]interface{}); ok {
+			if strings.Contains(lowerKey, headerMapKey) {
+				redacted[k] = redactAll(m)
+			} else {
+				redacted[k] = Config(m)
+			}
+			continue
+		}
+		redacted[k] = redactValue(v)
+		for _, s := range SecretKeys {
+			if strings.Contains(lowerKey, s) {
+				redacted[k] = Placeholder
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// redactAll returns a copy of a map with all values replaced with Placeholder.
+func redactAll(m map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			redacted[k] = redactAll(sub)
+			continue
+		}
+		redacted[k] = Placeholder
+	}
+	return redacted
+}
+
+// redactValue strips userinfo from URLs in a string or list value. Other
+// values are returned unchanged.
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return URL(v)
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			redacted[i] = URL(s)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, e := range v {
+			redacted[i] = redactValue(e)
+		}
+		return redacted
+	}
+	return v
+}
+
+// URL strips userinfo (such as `user:pass@`) from an absolute URL. Strings
+// which aren't absolute URLs with userinfo are returned unchanged.
+func URL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.User == nil {
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,95 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// ExpiresParam is the query parameter containing the expiry time of a
+	// signed URL as a Unix timestamp.
+	ExpiresParam = "expires"
+
+	// SignatureParam is the query parameter containing the signature of a
+	// signed URL.
+	SignatureParam = "sig"
+)
+
+var (
+	// ErrMissing is returned when a URL has no signature.
+	ErrMissing = errors.New("missing signature")
+
+	// ErrExpired is returned when a signed URL has expired.
+	ErrExpired = errors.New("signature expired")
+
+	// ErrInvalid is returned when a signature doesn't match any secret.
+	ErrInvalid = errors.New("invalid signature")
+)
+
+// Signer creates and verifies HMAC-SHA256 signatures for URLs. Signatures
+// cover the bucket, path and expiry time, so they can't be reused for other
+// objects or extended.
+type Signer struct {
+	secrets [][]byte
+}
+
+// New creates a new *Signer. New signatures are created with the first secret,
+// and signatures made with any secret are accepted, so secrets can be rotated
+// by adding a new secret to the front and removing the old one once all URLs
+// signed with it have expired.
+func New(secrets []string) (*Signer, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("at least one secret is required")
+	}
+	s := &Signer{secrets: make([][]byte, len(secrets))}
+	for i, secret := range secrets {
+		if secret == "" {
+			return nil, errors.New("secrets must not be empty")
+		}
+		s.secrets[i] = []byte(secret)
+	}
+	return s, nil
+}
+
+// Sign returns the signature for a path in bucket expiring at expires.
+func (s *Signer) Sign(bucket, path string, expires time.Time) string {
+	return sign(s.secrets[0], bucket, path, strconv.FormatInt(expires.Unix(), 10))
+}
+
+// Verify checks the expiry time and signature from a request for a path in
+// bucket.
+func (s *Signer) Verify(bucket, path, expires, sig string, now time.Time) error {
+	if expires == "" || sig == "" {
+		return ErrMissing
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	// Check the signature first, so expired URLs with a forged signature are
+	// reported as invalid
+	valid := false
+	for _, secret := range s.secrets {
+		if hmac.Equal([]byte(sign(secret, bucket, path, expires)), []byte(sig)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalid
+	}
+	if now.Unix() >= expiresUnix {
+		return ErrExpired
+	}
+	return nil
+}
+
+// sign computes a signature with a single secret.
+func sign(secret []byte, bucket, path, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(bucket + "\n" + path + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
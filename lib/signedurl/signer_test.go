@@ -0,0 +1,81 @@
+package signedurl
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		secrets []string
+		wantErr bool
+	}{
+		{nil, true},
+		{[]string{""}, true},
+		{[]string{"new", ""}, true},
+		{[]string{"secret"}, false},
+		{[]string{"new", "old"}, false},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.secrets); (err != nil) != tt.wantErr {
+			t.Errorf("New(%q) error = %v, wantErr %v", tt.secrets, err, tt.wantErr)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	expires := now.Add(time.Hour)
+	expiresParam := strconv.FormatInt(expires.Unix(), 10)
+
+	oldSigner, _ := New([]string{"old"})
+	signer, _ := New([]string{"new", "old"})
+	sig := signer.Sign("public", "/file.png", expires)
+	oldSig := oldSigner.Sign("public", "/file.png", expires)
+	otherSig, _ := New([]string{"other"})
+
+	tests := []struct {
+		name    string
+		bucket  string
+		path    string
+		expires string
+		sig     string
+		now     time.Time
+		want    error
+	}{
+		{"valid", "public", "/file.png", expiresParam, sig, now, nil},
+		{"valid with old secret", "public", "/file.png", expiresParam, oldSig, now, nil},
+		{"valid until expiry", "public", "/file.png", expiresParam, sig, expires.Add(-time.Second), nil},
+		{"expired at expiry", "public", "/file.png", expiresParam, sig, expires, ErrExpired},
+		{"expired", "public", "/file.png", expiresParam, sig, expires.Add(time.Hour), ErrExpired},
+		{"missing signature", "public", "/file.png", expiresParam, "", now, ErrMissing},
+		{"missing expiry", "public", "/file.png", "", sig, now, ErrMissing},
+		{"invalid expiry", "public", "/file.png", "soon", sig, now, ErrInvalid},
+		{"extended expiry", "public", "/file.png", strconv.FormatInt(expires.Unix()+1, 10), sig, now, ErrInvalid},
+		{"other path", "public", "/other.png", expiresParam, sig, now, ErrInvalid},
+		{"other bucket", "private", "/file.png", expiresParam, sig, now, ErrInvalid},
+		{"unknown secret", "public", "/file.png", expiresParam, otherSig.Sign("public", "/file.png", expires), now, ErrInvalid},
+		{"forged and expired", "public", "/file.png", expiresParam, "forged", expires.Add(time.Hour), ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signer.Verify(tt.bucket, tt.path, tt.expires, tt.sig, tt.now); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignUsesFirstSecret(t *testing.T) {
+	expires := time.Unix(1500000000, 0)
+	a, _ := New([]string{"a", "b"})
+	b, _ := New([]string{"b"})
+	if a.Sign("public", "/file.png", expires) == b.Sign("public", "/file.png", expires) {
+		t.Error("Sign() used a secret other than the first")
+	}
+	aOnly, _ := New([]string{"a"})
+	if a.Sign("public", "/file.png", expires) != aOnly.Sign("public", "/file.png", expires) {
+		t.Error("Sign() didn't use the first secret")
+	}
+}
@@ -0,0 +1,145 @@
+package sniffer
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// sniffLength is the maximum number of bytes read from a file to detect its
+// content type.
+const sniffLength = 512
+
+// DefaultAllowedTypes are passive content types that are safe to serve inline
+// when detected.
+var DefaultAllowedTypes = []string{
+	"application/ogg",
+	"application/pdf",
+	"audio/aiff",
+	"audio/basic",
+	"audio/midi",
+	"audio/mpeg",
+	"audio/wave",
+	"font/otf",
+	"font/ttf",
+	"font/woff",
+	"font/woff2",
+	"image/bmp",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"image/x-icon",
+	"text/plain",
+	"video/avi",
+	"video/mp4",
+	"video/webm",
+}
+
+// activeTypes are content types that can execute scripts when rendered by a
+// browser. They are never returned by a Sniffer, even if allowed.
+var activeTypes = map[string]struct{}{
+	"application/javascript": struct{}{},
+	"application/xml":        struct{}{},
+	"image/svg+xml":          struct{}{},
+	"text/html":              struct{}{},
+	"text/javascript":        struct{}{},
+	"text/xml":               struct{}{},
+}
+
+// Sniffer detects the content type of stored files from their first bytes.
+// Results are cached per key, which should be the file's hash.
+type Sniffer struct {
+	allowedTypes map[string]struct{}
+	cacheSize    int
+
+	mu    sync.Mutex
+	cache map[string]string
+	order []string
+}
+
+// New creates a new *Sniffer which only returns content types in allowedTypes
+// and caches up to cacheSize results.
+func New(allowedTypes []string, cacheSize int) *Sniffer {
+	s := &Sniffer{
+		allowedTypes: make(map[string]struct{}, len(allowedTypes)),
+		cacheSize:    cacheSize,
+		cache:        make(map[string]string),
+	}
+	for _, t := range allowedTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if _, ok := activeTypes[t]; !ok {
+			s.allowedTypes[t] = struct{}{}
+		}
+	}
+	return s
+}
+
+// Sniff returns the detected content type of the file at path. If the detected
+// type is not allowed, an empty string is returned.
+func (s *Sniffer) Sniff(key, path string) (string, error) {
+	s.mu.Lock()
+	contentType, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok {
+		return contentType, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	contentType = http.DetectContentType(buf[:n])
+	typ, _, err := mime.ParseMediaType(contentType)
+	if _, ok := s.allowedTypes[typ]; err != nil || !ok {
+		contentType = ""
+	}
+	s.set(key, contentType)
+	return contentType, nil
+}
+
+// Delete removes a cached result.
+func (s *Sniffer) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[key]; !ok {
+		return
+	}
+	delete(s.cache, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// set stores a result in the cache, evicting the oldest result if the cache is
+// full.
+func (s *Sniffer) set(key, contentType string) {
+	if s.cacheSize <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[key]; ok {
+		s.cache[key] = contentType
+		return
+	}
+	for len(s.order) >= s.cacheSize {
+		delete(s.cache, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.cache[key] = contentType
+	s.order = append(s.order, key)
+}
@@ -0,0 +1,53 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket of bytes, refilled at a fixed rate. It is safe for
+// concurrent use, so a single Bucket can limit many connections at once.
+type Bucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a new *Bucket allowing rate bytes per second, with bursts
+// of up to one second worth of data.
+func NewBucket(rate int64) *Bucket {
+	return &Bucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n bytes may be sent. n should not be larger than the rate.
+func (b *Bucket) Wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	// Tokens are reserved before sleeping, so concurrent writers queue up
+	// behind each other instead of all waking at once
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// Rate returns the rate of b in bytes per second.
+func (b *Bucket) Rate() int64 {
+	return int64(b.rate)
+}
@@ -0,0 +1,142 @@
+package throttle
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxChunkSize is the largest write made to a throttled connection at once,
+// which keeps throughput smooth at low rates.
+const maxChunkSize = 32 * 1024
+
+// Listener wraps a net.Listener so accepted connections can be throttled.
+type Listener struct {
+	net.Listener
+}
+
+// NewListener creates a new *Listener.
+func NewListener(ln net.Listener) *Listener {
+	return &Listener{Listener: ln}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		return &TLSConn{Conn: &Conn{Conn: c}, tls: tc}, nil
+	}
+	return &Conn{Conn: c}, nil
+}
+
+// From returns the *Conn of a connection accepted by a *Listener, or nil if
+// the connection can't be throttled.
+func From(c net.Conn) *Conn {
+	switch c := c.(type) {
+	case *Conn:
+		return c
+	case *TLSConn:
+		return c.Conn
+	}
+	return nil
+}
+
+// Conn is a connection whose writes can be limited by one or more buckets.
+// Unthrottled connections pass io.ReaderFrom through to the underlying
+// connection, so files are still served with sendfile(2).
+type Conn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	buckets []*Bucket
+}
+
+// Throttle limits writes to c by all of buckets, until Unthrottle is called.
+func (c *Conn) Throttle(buckets ...*Bucket) {
+	c.mu.Lock()
+	c.buckets = buckets
+	c.mu.Unlock()
+}
+
+// Unthrottle removes all limits from c.
+func (c *Conn) Unthrottle() {
+	c.Throttle()
+}
+
+// limits returns the current buckets of c.
+func (c *Conn) limits() []*Bucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buckets
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(p []byte) (int, error) {
+	buckets := c.limits()
+	if len(buckets) == 0 {
+		return c.Conn.Write(p)
+	}
+
+	chunkSize := maxChunkSize
+	for _, b := range buckets {
+		if rate := int(b.Rate()); rate < chunkSize {
+			chunkSize = rate
+		}
+	}
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		for _, b := range buckets {
+			b.Wait(len(chunk))
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom implements io.ReaderFrom. Unthrottled connections use the
+// underlying connection's ReadFrom if it has one.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok && len(c.limits()) == 0 {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(writerOnly{c}, r)
+}
+
+// writerOnly hides the ReadFrom method of a writer from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// TLSConn is a *Conn wrapping a TLS connection. It exposes the TLS connection
+// state, so servers can still tell the connection is secure.
+type TLSConn struct {
+	*Conn
+
+	tls *tls.Conn
+}
+
+// Handshake runs the TLS handshake.
+func (c *TLSConn) Handshake() error {
+	return c.tls.Handshake()
+}
+
+// ConnectionState returns the TLS connection state.
+func (c *TLSConn) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
@@ -45,9 +45,10 @@ func (c *ThumbnailCache) SetThumbnail(key string, data io.Reader) error {
 	return err
 }
 
-// Transform generates a thumbnail and caches it.
-func (c *ThumbnailCache) Transform(key string, contentType string, data io.Reader) error {
-	outputImage, err := Transform(c.ThumbnailerURL, contentType, data)
+// Transform generates a thumbnail and caches it. Additional headers are
+// forwarded to the thumbnailer service.
+func (c *ThumbnailCache) Transform(key string, contentType string, data io.Reader, headers map[string]string) error {
+	outputImage, err := Transform(c.ThumbnailerURL, contentType, data, headers)
 	if err != nil {
 		return err
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
@@ -25,8 +26,9 @@ func AcceptedMIMEType(mime string) bool {
 }
 
 // Transform takes an image io.Reader and sends it to the thumbnailer service
-// to be transcoded into a thumbnail.
-func Transform(thumbnailerURL, contentType string, data io.Reader) (*bytes.Buffer, error) {
+// to be transcoded into a thumbnail. Additional headers (such as a request ID)
+// are forwarded to the thumbnailer service.
+func Transform(thumbnailerURL, contentType string, data io.Reader, headers map[string]string) (*bytes.Buffer, error) {
 	// Set request and response
 	req := fasthttp.AcquireRequest()
 	res := fasthttp.AcquireResponse()
@@ -39,6 +41,9 @@ func Transform(thumbnailerURL, contentType string, data io.Reader) (*bytes.Buffe
 	req.Header.SetMethod("POST")
 	req.SetRequestURI(thumbnailerURL)
 	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	_, err := io.Copy(req.BodyWriter(), data)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to copy data to request")
@@ -56,3 +61,21 @@ func Transform(thumbnailerURL, contentType string, data io.Reader) (*bytes.Buffe
 
 	return bytes.NewBuffer(res.Body()), nil
 }
+
+// Ping checks if the thumbnailer service is reachable. Any HTTP response is
+// considered a success, as the service might not accept GET requests.
+func Ping(thumbnailerURL string, timeout time.Duration) error {
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}()
+
+	req.SetRequestURI(thumbnailerURL)
+	err := fasthttp.DoTimeout(req, res, timeout)
+	if err != nil {
+		return errors.Wrap(err, "failed to make request to thumbnailer service")
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+// Exporter exports ended spans.
+type Exporter interface {
+	Export(serviceName string, spans []*Span) error
+}
+
+// OTLPExporter exports spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the traces endpoint of the collector, such as
+	// http://localhost:4318/v1/traces.
+	Endpoint string
+
+	// Headers are additional request headers, such as authentication.
+	Headers map[string]string
+}
+
+// Export implements Exporter.
+func (e *OTLPExporter) Export(serviceName string, spans []*Span) error {
+	body, err := json.Marshal(encodeOTLP(serviceName, spans))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode spans")
+	}
+
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}()
+	req.Header.SetMethod("POST")
+	req.SetRequestURI(e.Endpoint)
+	req.Header.SetContentType("application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	req.SetBody(body)
+
+	err = fasthttp.Do(req, res)
+	if err != nil {
+		return errors.Wrap(err, "failed to make request to OTLP collector")
+	}
+	if res.StatusCode() < 200 || res.StatusCode() > 299 {
+		return errors.Errorf("OTLP collector returned status code %d: %s", res.StatusCode(), string(res.Body()))
+	}
+	return nil
+}
+
+// WriterExporter writes spans to an io.Writer (such as stdout) as OTLP JSON,
+// one export request per line.
+type WriterExporter struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+// Export implements Exporter.
+func (e *WriterExporter) Export(serviceName string, spans []*Span) error {
+	body, err := json.Marshal(encodeOTLP(serviceName, spans))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode spans")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.W.Write(append(body, '\n'))
+	return err
+}
+
+// OTLP JSON types, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// encodeOTLP converts spans to an OTLP export request.
+func encodeOTLP(serviceName string, spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		encoded[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentSpanID != (SpanID{}) {
+			encoded[i].ParentSpanID = hex.EncodeToString(s.parentSpanID[:])
+		}
+		for k, v := range s.attributes {
+			encoded[i].Attributes = append(encoded[i].Attributes, encodeAttribute(k, v))
+		}
+		if s.err != nil {
+			encoded[i].Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{encodeAttribute("service.name", serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: serviceName},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+// encodeAttribute converts an attribute to an OTLP key-value pair.
+func encodeAttribute(key string, value interface{}) otlpAttribute {
+	var v map[string]interface{}
+	switch value := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": value}
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID is a W3C trace ID.
+type TraceID [16]byte
+
+// SpanID is a W3C span ID.
+type SpanID [8]byte
+
+// SpanKind is the kind of a span, using OTLP values.
+type SpanKind int
+
+const (
+	// KindInternal is an internal operation.
+	KindInternal SpanKind = 1
+
+	// KindServer is the handling of an inbound request.
+	KindServer SpanKind = 2
+
+	// KindClient is an outbound request.
+	KindClient SpanKind = 3
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns true if the trace ID and span ID are both non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the W3C traceparent header value for the span context.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value. False is returned if
+// the value is invalid.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 ||
+		len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Span is a single timed operation in a trace. All methods are safe to call on
+// a nil *Span, which is returned for unsampled operations.
+type Span struct {
+	tracer       *Tracer
+	name         string
+	kind         SpanKind
+	context      SpanContext
+	parentSpanID SpanID
+	start        time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+}
+
+// Context returns the span's context. For a nil span, an invalid span context
+// is returned.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute sets an attribute on the span. Values should be strings, bools,
+// ints, int64s or float64s; other values are formatted as strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End ends the span and queues it for export. Only the first call has an
+// effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// newTraceID generates a random trace ID.
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+// newSpanID generates a random span ID.
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// exportBatchSize is the maximum number of spans exported at once.
+const exportBatchSize = 512
+
+// Tracer creates spans and exports them in batches. All methods are safe to
+// call on a nil *Tracer, which creates no spans.
+type Tracer struct {
+	exporter    Exporter
+	serviceName string
+	sampleRatio float64
+	interval    time.Duration
+	queue       chan *Span
+}
+
+// New creates a new *Tracer and starts its export worker. Root spans are
+// sampled with the probability sampleRatio (0 to 1), while child spans and
+// spans with a remote parent follow their parent's sampling decision. Queued
+// spans are exported every interval or when a full batch is available.
+func New(exporter Exporter, serviceName string, sampleRatio float64, interval time.Duration, queueSize int) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		serviceName: serviceName,
+		sampleRatio: sampleRatio,
+		interval:    interval,
+		queue:       make(chan *Span, queueSize),
+	}
+	go t.work()
+	return t
+}
+
+// Start starts a new span. If parent is valid, the span joins the parent's
+// trace, otherwise a new trace is started. If the span isn't sampled, nil is
+// returned.
+func (t *Tracer) Start(parent SpanContext, name string, kind SpanKind) *Span {
+	if t == nil {
+		return nil
+	}
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+	if !sc.Sampled {
+		return nil
+	}
+	return &Span{
+		tracer:       t,
+		name:         name,
+		kind:         kind,
+		context:      sc,
+		parentSpanID: parent.SpanID,
+		start:        time.Now(),
+	}
+}
+
+// StartChild starts a new span as a child of parent. If parent is nil, nil is
+// returned.
+func (t *Tracer) StartChild(parent *Span, name string, kind SpanKind) *Span {
+	if parent == nil {
+		return nil
+	}
+	return t.Start(parent.Context(), name, kind)
+}
+
+// sample makes a sampling decision from the trace ID, so the same trace is
+// always sampled the same way.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>1) < t.sampleRatio*float64(1<<63)
+}
+
+// enqueue queues an ended span for export. If the queue is full, the span is
+// dropped.
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		log.Debug().Msg("trace export queue is full, dropping span")
+	}
+}
+
+// work exports queued spans in batches.
+func (t *Tracer) work() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, exportBatchSize)
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < exportBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.exporter.Export(t.serviceName, batch); err != nil {
+			log.Warn().Err(err).Int("spans", len(batch)).Msg("failed to export spans")
+		}
+		batch = make([]*Span, 0, exportBatchSize)
+	}
+}
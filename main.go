@@ -2,22 +2,46 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"mime"
 	"net"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
+	"owo.codes/whats-this/cdn-origin/lib/accesslog"
+	"owo.codes/whats-this/cdn-origin/lib/cachecontrol"
+	"owo.codes/whats-this/cdn-origin/lib/certs"
+	"owo.codes/whats-this/cdn-origin/lib/clientip"
+	"owo.codes/whats-this/cdn-origin/lib/contentpolicy"
 	"owo.codes/whats-this/cdn-origin/lib/db"
+	"owo.codes/whats-this/cdn-origin/lib/listener"
 	"owo.codes/whats-this/cdn-origin/lib/metrics"
+	"owo.codes/whats-this/cdn-origin/lib/proxyproto"
+	"owo.codes/whats-this/cdn-origin/lib/purger"
+	"owo.codes/whats-this/cdn-origin/lib/signedurl"
+	"owo.codes/whats-this/cdn-origin/lib/sniffer"
+	"owo.codes/whats-this/cdn-origin/lib/throttle"
 	"owo.codes/whats-this/cdn-origin/lib/thumbnailer"
+	"owo.codes/whats-this/cdn-origin/lib/tracing"
 
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
@@ -34,13 +58,49 @@ const (
 )
 
 const (
-	rawParam = "_raw"
+	rawParam      = "_raw"
+	infoParam     = "info"
+	downloadParam = "download"
+
+	// hashRoutePrefix is the path prefix for content-addressed file URLs.
+	hashRoutePrefix = "/.sha256/"
+
+	// purgePath is the path of the authenticated purge endpoint.
+	purgePath = "/.purge"
 )
 
 var (
 	discordBotRegex = regexp.MustCompile("(?i)discordbot")
 )
 
+// objectTypes maps object type enumerables to their names.
+var objectTypes = map[int]string{
+	0: "file",
+	1: "redirect",
+	2: "tombstone",
+}
+
+// cacheControlClasses are the response classes Cache-Control policies can be
+// configured for.
+var cacheControlClasses = []string{"file", "hash", "thumbnail", "redirect", "info", "notFound", "gone"}
+
+// objectInfo is the JSON representation of an object's metadata returned to
+// clients.
+type objectInfo struct {
+	Key           string     `json:"key"`
+	Type          string     `json:"type"`
+	ContentType   *string    `json:"content_type,omitempty"`
+	ContentLength *int64     `json:"content_length,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	MD5Hash       *string    `json:"md5_hash,omitempty"`
+	SHA256Hash    *string    `json:"sha256_hash,omitempty"`
+	DestURL       *string    `json:"dest_url,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	DeleteReason  *string    `json:"delete_reason,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	ViewsLeft     *int64     `json:"views_left,omitempty"`
+}
+
 // readCloserBuffer is a *bytes.Buffer that implements io.ReadCloser.
 type readCloserBuffer struct {
 	*bytes.Buffer
@@ -73,6 +133,17 @@ const discordHTML = `<html>
 
 var discordHTMLTemplate *template.Template
 
+// filePreviewHTML is the html/template template for generating file preview HTML.
+const filePreviewHTML = `<html><head><meta charset="UTF-8" /><title>{{.Name}}</title></head><body>{{if .Image}}<img src="{{.URL}}" alt="{{.Name}}" />{{else}}<p>Click <a href="{{.URL}}">here</a> to view <code>{{.Name}}</code>.</p>{{end}}</body></html>`
+
+var filePreviewHTMLTemplate *template.Template
+
+// unlockHTML is the html/template template for generating the password form
+// of protected objects.
+const unlockHTML = `<html><head><meta charset="UTF-8" /><meta name="robots" content="noindex" /><title>Password Required</title></head><body><form method="POST"><p>This file is password protected.</p>{{if .}}<p>Incorrect password, please try again.</p>{{end}}<input type="password" name="password" autofocus /> <button type="submit">Unlock</button></form></body></html>`
+
+var unlockHTMLTemplate *template.Template
+
 var redirectPreviewHTMLTemplate *template.Template
 
 // printConfiguration iterates through a configuration map[string]interface{}
@@ -106,13 +177,112 @@ func init() {
 	flags.Parse(os.Args)
 
 	// Configuration defaults
+	viper.SetDefault("accessLog.enable", false)
+	viper.SetDefault("accessLog.format", "json")
+	viper.SetDefault("accessLog.path", "")
+	viper.SetDefault("accessLog.truncateIPs", false)
+	viper.SetDefault("accessLog.truncateIPv4Bits", 24)
+	viper.SetDefault("accessLog.truncateIPv6Bits", 48)
+	viper.SetDefault("admin.listenAddress", "")
+	viper.SetDefault("bandwidth.enable", false)
+	viper.SetDefault("bandwidth.allowlist", []string{})
+	viper.SetDefault("bandwidth.allowlistHosts", []string{})
+	viper.SetDefault("bandwidth.allowlistRate", 0)
+	viper.SetDefault("bandwidth.globalRate", 0)
+	viper.SetDefault("bandwidth.minSize", 10*1024*1024) // 10 MiB
+	viper.SetDefault("bandwidth.perConnectionRate", 0)
+	viper.SetDefault("blocklist.enable", false)
+	viper.SetDefault("blocklist.database", false)
+	viper.SetDefault("blocklist.file", "")
+	viper.SetDefault("blocklist.reason", "this file has been blocked")
+	viper.SetDefault("blocklist.reloadInterval", time.Minute)
+	viper.SetDefault("blocklist.status", fasthttp.StatusUnavailableForLegalReasons)
+	for _, class := range cacheControlClasses {
+		viper.SetDefault("cacheControl."+class+".maxAge", -1)
+	}
+	viper.SetDefault("cacheControl.hash.maxAge", 31536000) // 1 year
+	viper.SetDefault("cacheControl.hash.immutable", true)
+	viper.SetDefault("cacheControl.staleIfError", 0)
+	viper.SetDefault("cacheControl.staleWhileRevalidate", 0)
 	viper.SetDefault("database.objectBucket", "public")
+	viper.SetDefault("files.contentDisposition", true)
+	viper.SetDefault("files.enableHashRoute", false)
+	viper.SetDefault("files.sniffContentType", false)
+	viper.SetDefault("files.sniffAllowedTypes", sniffer.DefaultAllowedTypes)
+	viper.SetDefault("files.sniffCacheSize", 10000)
+	viper.SetDefault("files.sniffGenericTypes", []string{"application/octet-stream", "binary/octet-stream", "application/unknown"})
+	viper.SetDefault("hotlink.enable", false)
+	viper.SetDefault("hotlink.action", "forbidden")
+	viper.SetDefault("hotlink.allowEmptyReferer", true)
+	viper.SetDefault("hotlink.placeholderPath", "")
 	viper.SetDefault("http.compressResponse", false)
+	viper.SetDefault("http.healthPath", "/.healthz")
 	viper.SetDefault("http.listenAddress", ":49544")
+	viper.SetDefault("http.objectInfoAcceptJSON", false)
+	viper.SetDefault("http.proxyProtocol", false)
+	viper.SetDefault("http.proxyProtocolSources", []string{})
+	viper.SetDefault("http.proxyProtocolTimeout", time.Second*5)
+	viper.SetDefault("http.readyPath", "/.readyz")
+	viper.SetDefault("http.tls.enable", false)
+	viper.SetDefault("http.tls.minVersion", "1.2")
+	viper.SetDefault("http.tls.redirectListenAddress", "")
+	viper.SetDefault("http.tls.redirectPort", "")
+	viper.SetDefault("http.tls.reloadInterval", time.Minute)
+	viper.SetDefault("http.requestIDHeader", "X-Request-ID")
+	viper.SetDefault("http.readyTimeout", time.Second*5)
 	viper.SetDefault("http.trustProxy", false)
+	viper.SetDefault("http.unixSocketMode", "0660")
+	viper.SetDefault("http.trustedProxies", []string{})
+	viper.SetDefault("http.clientIPHeaders", []string{})
+	viper.SetDefault("http.useForwardedHeader", true)
 	viper.BindPFlag("log.level", flags.Lookup("log-level")) // default is 1 (info)
 	viper.SetDefault("metrics.enable", false)
 	viper.SetDefault("metrics.enableHostnameWhitelist", false)
+	viper.SetDefault("moderation.graceMode", false)
+	viper.SetDefault("moderation.graceAllowlist", []string{})
+	viper.SetDefault("passwords.enable", false)
+	viper.SetDefault("passwords.cookieSecrets", []string{})
+	viper.SetDefault("passwords.cookieTTL", time.Hour)
+	viper.SetDefault("passwords.secureCookie", false)
+	viper.SetDefault("purger.enable", false)
+	viper.SetDefault("purger.backend", "cloudflare")
+	viper.SetDefault("purger.cloudflare.endpoint", "https://api.cloudflare.com/client/v4/zones/ZONE_ID/purge_cache")
+	viper.SetDefault("purger.http.method", "POST")
+	viper.SetDefault("purger.http.contentType", "application/json")
+	viper.SetDefault("purger.listenChannel", "")
+	viper.SetDefault("purger.maxAttempts", 5)
+	viper.SetDefault("purger.queueSize", 1000)
+	viper.SetDefault("purger.retryDelay", time.Second)
+	viper.SetDefault("purger.timeout", purger.DefaultTimeout)
+	viper.SetDefault("purger.variants", purger.DefaultVariants)
+	viper.SetDefault("rateLimit.enable", false)
+	viper.SetDefault("rateLimit.allowlist", []string{})
+	viper.SetDefault("rateLimit.fetch.burst", 50)
+	viper.SetDefault("rateLimit.fetch.rate", 10.0)
+	viper.SetDefault("rateLimit.maxConnections", 0)
+	viper.SetDefault("rateLimit.subnetIPv4Bits", 32)
+	viper.SetDefault("rateLimit.subnetIPv6Bits", 64)
+	viper.SetDefault("rateLimit.thumbnail.burst", 10)
+	viper.SetDefault("rateLimit.thumbnail.rate", 1.0)
+	viper.SetDefault("security.enable", false)
+	viper.SetDefault("security.noSniff", true)
+	viper.SetDefault("security.contentSecurityPolicy", "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox")
+	viper.SetDefault("security.riskyTypeAction", "text")
+	viper.SetDefault("security.riskyTypes", contentpolicy.DefaultRiskyTypes)
+	viper.SetDefault("signedURLs.enable", false)
+	viper.SetDefault("signedURLs.maxTTL", time.Hour*24*7)
+	viper.SetDefault("signedURLs.requiredBuckets", []string{})
+	viper.SetDefault("signedURLs.secrets", []string{})
+	viper.SetDefault("surrogateKeys.enable", false)
+	viper.SetDefault("surrogateKeys.headers", []string{"Surrogate-Key", "Cache-Tag"})
+	viper.SetDefault("surrogateKeys.prefix", "")
+	viper.SetDefault("tracing.enable", false)
+	viper.SetDefault("tracing.endpoint", "http://localhost:4318/v1/traces")
+	viper.SetDefault("tracing.exportInterval", time.Second*5)
+	viper.SetDefault("tracing.exporter", "otlp")
+	viper.SetDefault("tracing.queueSize", 2048)
+	viper.SetDefault("tracing.sampleRatio", 1.0)
+	viper.SetDefault("tracing.serviceName", "cdn-origin")
 
 	// Load configuration file
 	viper.SetConfigType("toml")
@@ -160,12 +330,21 @@ func init() {
 	if viper.GetBool("metrics.enable") && viper.GetBool("metrics.enableHostnameWhitelist") && len(viper.GetStringSlice("metrics.hostnameWhitelist")) == 0 {
 		log.Fatal().Msg("Configuration: metrics.hostnameWhitelist is required when metrics and hostname whitelist is enabled")
 	}
-	if viper.GetString("http.listenAddress") == "" {
+	if len(viper.GetStringSlice("http.listenAddress")) == 0 {
 		log.Fatal().Msg("Configuration: http.listenAddress is required")
 	}
+	if viper.GetString("admin.listenAddress") != "" && viper.GetString("admin.token") == "" {
+		log.Fatal().Msg("Configuration: admin.token is required when the admin API is enabled")
+	}
+	if viper.GetBool("http.proxyProtocol") && len(viper.GetStringSlice("http.proxyProtocolSources")) == 0 {
+		log.Fatal().Msg("Configuration: http.proxyProtocolSources is required when PROXY protocol is enabled")
+	}
 	if viper.GetString("files.storageLocation") == "" {
 		log.Fatal().Msg("Configuration: files.storageLocation is required")
 	}
+	if viper.GetBool("purger.enable") && len(viper.GetStringSlice("purger.baseURLs")) == 0 {
+		log.Fatal().Msg("Configuration: purger.baseURLs is required when the purger is enabled")
+	}
 	if viper.GetBool("thumbnails.enable") && viper.GetString("thumbnails.thumbnailerURL") == "" {
 		log.Fatal().Msg("thumbnails.thumbnailerURL is required when thumbnails are enabled")
 	}
@@ -173,6 +352,10 @@ func init() {
 		log.Fatal().Msg("thumbnails.cacheLocation is required when thumbnails and thumbnails cache is enabled")
 	}
 
+	if status := viper.GetInt("blocklist.status"); status != fasthttp.StatusUnavailableForLegalReasons && status != fasthttp.StatusGone {
+		log.Fatal().Msg("Configuration: blocklist.status must be 451 or 410")
+	}
+
 	// Parse redirect templates
 	redirectHTMLTemplate, err = template.New("redirectHTML").Parse(redirectHTML)
 	if err != nil {
@@ -186,9 +369,25 @@ func init() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to parse discordHTML template")
 	}
+	filePreviewHTMLTemplate, err = template.New("filePreviewHTML").Parse(filePreviewHTML)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse filePreviewHTML template")
+	}
+	unlockHTMLTemplate, err = template.New("unlockHTML").Parse(unlockHTML)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse unlockHTML template")
+	}
 }
 
+var accessLogger *accesslog.Logger
+var cacheControlTable cachecontrol.Table
+var clientIPResolver *clientip.Resolver
 var collector *metrics.Collector
+var httpServer *fasthttp.Server
+var objectPurger *purger.Purger
+var contentPolicy *contentpolicy.Policy
+var contentSniffer *sniffer.Sniffer
+var sniffGenericTypes map[string]struct{}
 var thumbnailCache *thumbnailer.ThumbnailCache
 
 func main() {
@@ -198,6 +397,147 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to open database connection")
 	}
 
+	// Setup client IP resolver
+	if viper.GetBool("http.trustProxy") {
+		trustedProxies := viper.GetStringSlice("http.trustedProxies")
+		if len(trustedProxies) == 0 {
+			log.Warn().Msg("http.trustProxy is enabled without http.trustedProxies, trusting forwarding headers from all peers")
+		}
+		clientIPResolver, err = clientip.New(
+			trustedProxies,
+			viper.GetStringSlice("http.clientIPHeaders"),
+			viper.GetBool("http.useForwardedHeader"),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid http.trustedProxies")
+		}
+	}
+
+	// Setup rate limiting
+	if viper.GetBool("rateLimit.enable") {
+		if err := setupRateLimit(); err != nil {
+			log.Fatal().Err(err).Msg("failed to setup rate limiting")
+		}
+	}
+
+	// Setup signed URLs
+	if viper.GetBool("signedURLs.enable") {
+		urlSigner, err = signedurl.New(viper.GetStringSlice("signedURLs.secrets"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid signedURLs.secrets")
+		}
+	}
+
+	// Setup hash blocklist
+	if viper.GetBool("blocklist.enable") {
+		if err := setupBlocklist(); err != nil {
+			log.Fatal().Err(err).Msg("failed to load hash blocklist")
+		}
+	}
+
+	// Setup moderation grace mode
+	if viper.GetBool("moderation.graceMode") {
+		moderationAllowlist, err = clientip.ParseCIDRs(viper.GetStringSlice("moderation.graceAllowlist"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid moderation.graceAllowlist")
+		}
+	}
+
+	// Setup password-protected objects
+	if viper.GetBool("passwords.enable") {
+		if !db.HasColumn("password_hash") {
+			log.Warn().Msg("passwords.enable is set but the objects table has no password_hash column")
+		}
+		unlockSigner, err = signedurl.New(viper.GetStringSlice("passwords.cookieSecrets"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid passwords.cookieSecrets")
+		}
+	}
+
+	// Setup hotlink protection
+	if viper.GetBool("hotlink.enable") {
+		if err := setupHotlink(); err != nil {
+			log.Fatal().Err(err).Msg("failed to setup hotlink protection")
+		}
+	}
+
+	// Setup bandwidth throttling
+	if viper.GetBool("bandwidth.enable") {
+		if err := setupBandwidth(); err != nil {
+			log.Fatal().Err(err).Msg("failed to setup bandwidth throttling")
+		}
+	}
+
+	// Setup access log
+	if viper.GetBool("accessLog.enable") {
+		format, err := accesslog.ParseFormat(viper.GetString("accessLog.format"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid accessLog.format")
+		}
+		accessLogger, err = accesslog.New(viper.GetString("accessLog.path"), format)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to open access log")
+		}
+
+		// Reopen the access log on SIGUSR1 for log rotation
+		sigusr1 := make(chan os.Signal, 1)
+		signal.Notify(sigusr1, syscall.SIGUSR1)
+		go func() {
+			for range sigusr1 {
+				if err := accessLogger.Reopen(); err != nil {
+					log.Error().Err(err).Msg("failed to reopen access log")
+					continue
+				}
+				log.Info().Msg("reopened access log")
+			}
+		}()
+	}
+
+	// Setup tracer
+	if viper.GetBool("tracing.enable") {
+		var exporter tracing.Exporter
+		switch viper.GetString("tracing.exporter") {
+		case "otlp":
+			exporter = &tracing.OTLPExporter{
+				Endpoint: viper.GetString("tracing.endpoint"),
+				Headers:  viper.GetStringMapString("tracing.headers"),
+			}
+		case "stdout":
+			exporter = &tracing.WriterExporter{W: os.Stdout}
+		default:
+			log.Fatal().Str("exporter", viper.GetString("tracing.exporter")).Msg("unknown tracing.exporter")
+		}
+		tracer = tracing.New(
+			exporter,
+			viper.GetString("tracing.serviceName"),
+			viper.GetFloat64("tracing.sampleRatio"),
+			viper.GetDuration("tracing.exportInterval"),
+			viper.GetInt("tracing.queueSize"),
+		)
+	}
+
+	// Setup Cache-Control policies
+	cacheControlPolicies := map[string]cachecontrol.Policy{}
+	for _, class := range cacheControlClasses {
+		prefix := "cacheControl." + class + "."
+		policy := cachecontrol.Policy{
+			MaxAge:               viper.GetInt(prefix + "maxAge"),
+			Private:              viper.GetBool(prefix + "private"),
+			Immutable:            viper.GetBool(prefix + "immutable"),
+			NoStore:              viper.GetBool(prefix + "noStore"),
+			StaleWhileRevalidate: viper.GetInt("cacheControl.staleWhileRevalidate"),
+			StaleIfError:         viper.GetInt("cacheControl.staleIfError"),
+		}
+		if viper.IsSet(prefix + "staleWhileRevalidate") {
+			policy.StaleWhileRevalidate = viper.GetInt(prefix + "staleWhileRevalidate")
+		}
+		if viper.IsSet(prefix + "staleIfError") {
+			policy.StaleIfError = viper.GetInt(prefix + "staleIfError")
+		}
+		cacheControlPolicies[class] = policy
+	}
+	cacheControlTable = cachecontrol.NewTable(cacheControlPolicies)
+
 	// Setup metrics collector
 	if viper.GetBool("metrics.enable") {
 		hostnameWhitelist := []string{}
@@ -223,6 +563,93 @@ func main() {
 		}
 	}
 
+	// Setup content security policy
+	if viper.GetBool("security.enable") {
+		riskyAction, err := contentpolicy.ParseAction(viper.GetString("security.riskyTypeAction"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid security.riskyTypeAction")
+		}
+		overrides := map[string]contentpolicy.Action{}
+		for typ, name := range viper.GetStringMapString("security.typeOverrides") {
+			overrides[typ], err = contentpolicy.ParseAction(name)
+			if err != nil {
+				log.Fatal().Err(err).Str("type", typ).Msg("invalid action in security.typeOverrides")
+			}
+		}
+		contentPolicy = contentpolicy.New(viper.GetStringSlice("security.riskyTypes"), riskyAction, overrides)
+	}
+
+	// Setup content sniffer
+	if viper.GetBool("files.sniffContentType") {
+		contentSniffer = sniffer.New(viper.GetStringSlice("files.sniffAllowedTypes"), viper.GetInt("files.sniffCacheSize"))
+		sniffGenericTypes = map[string]struct{}{}
+		for _, typ := range viper.GetStringSlice("files.sniffGenericTypes") {
+			sniffGenericTypes[strings.ToLower(strings.TrimSpace(typ))] = struct{}{}
+		}
+	}
+
+	// Setup CDN purger
+	if viper.GetBool("purger.enable") {
+		var backend purger.Backend
+		switch viper.GetString("purger.backend") {
+		case "cloudflare":
+			backend = &purger.CloudflareBackend{
+				Endpoint: viper.GetString("purger.cloudflare.endpoint"),
+				Token:    viper.GetString("purger.cloudflare.token"),
+				Timeout:  viper.GetDuration("purger.timeout"),
+			}
+		case "http":
+			urlTemplate, err := texttemplate.New("url").Parse(viper.GetString("purger.http.url"))
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to parse purger.http.url template")
+			}
+			var bodyTemplate *texttemplate.Template
+			if body := viper.GetString("purger.http.body"); body != "" {
+				bodyTemplate, err = texttemplate.New("body").Parse(body)
+				if err != nil {
+					log.Fatal().Err(err).Msg("failed to parse purger.http.body template")
+				}
+			}
+			backend = &purger.HTTPBackend{
+				Method:        viper.GetString("purger.http.method"),
+				URL:           urlTemplate,
+				Body:          bodyTemplate,
+				ContentType:   viper.GetString("purger.http.contentType"),
+				Authorization: viper.GetString("purger.http.authorization"),
+				PerURL:        viper.GetBool("purger.http.perURL"),
+				Timeout:       viper.GetDuration("purger.timeout"),
+			}
+		case "memory":
+			backend = &purger.MemoryBackend{}
+		default:
+			log.Fatal().Str("backend", viper.GetString("purger.backend")).Msg("unknown purger.backend")
+		}
+		objectPurger = purger.New(
+			backend,
+			viper.GetStringSlice("purger.baseURLs"),
+			viper.GetStringSlice("purger.variants"),
+			viper.GetInt("purger.maxAttempts"),
+			viper.GetDuration("purger.retryDelay"),
+			viper.GetInt("purger.queueSize"),
+		)
+
+		// Listen for object changes
+		if channel := viper.GetString("purger.listenChannel"); channel != "" {
+			bucketPrefix := viper.GetString("database.objectBucket") + "/"
+			err = db.Listen(viper.GetString("database.connectionURL"), channel, func(bucketKey string) {
+				if !strings.HasPrefix(bucketKey, bucketPrefix) {
+					return
+				}
+				if !objectPurger.Enqueue(strings.TrimPrefix(bucketKey, bucketPrefix)) {
+					log.Warn().Str("bucket_key", bucketKey).Msg("purge queue is full, dropping object change notification")
+				}
+			})
+			if err != nil {
+				log.Fatal().Err(err).Str("channel", channel).Msg("failed to listen for object changes")
+			}
+		}
+	}
+
 	// Setup thumbnail cache
 	if viper.GetBool("thumbnails.enable") && viper.GetBool("thumbnails.cacheEnable") {
 		thumbnailCache = thumbnailer.NewThumbnailCache(viper.GetString("thumbnails.cacheLocation"),
@@ -230,26 +657,280 @@ func main() {
 	}
 
 	// Launch server
-	h := requestHandler
+	h := routeRequest
 	if viper.GetBool("http.compressResponse") {
 		h = fasthttp.CompressHandler(h)
 	}
-	listenAddress := viper.GetString("http.listenAddress")
-	log.Info().Str("listenAddress", listenAddress).Msg("Starting HTTP server")
-	server := &fasthttp.Server{
+	unixSocketMode, err := listener.ParseMode(viper.GetString("http.unixSocketMode"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid http.unixSocketMode")
+	}
+	if viper.GetString("admin.listenAddress") != "" {
+		adminListenAddress := viper.GetString("admin.listenAddress")
+		log.Info().Str("listenAddress", adminListenAddress).Msg("Starting admin HTTP server")
+		adminServer := &fasthttp.Server{
+			Handler:      adminHandler,
+			Name:         "whats-this/cdn-origin v" + version,
+			ReadTimeout:  time.Minute,
+			WriteTimeout: time.Minute,
+		}
+		ln, err := listener.Listen(adminListenAddress, unixSocketMode)
+		if err != nil {
+			log.Fatal().Err(err).Str("listenAddress", adminListenAddress).Msg("failed to listen")
+		}
+		go func() {
+			if err := adminServer.Serve(ln); err != nil {
+				log.Fatal().Err(err).Msg("error in adminServer.Serve")
+			}
+		}()
+	}
+	if viper.GetBool("http.tls.enable") {
+		if redirectAddress := viper.GetString("http.tls.redirectListenAddress"); redirectAddress != "" {
+			log.Info().Str("listenAddress", redirectAddress).Msg("Starting HTTP to HTTPS redirect server")
+			redirectServer := &fasthttp.Server{
+				Handler:      httpsRedirectHandler,
+				Name:         "whats-this/cdn-origin v" + version,
+				ReadTimeout:  time.Minute,
+				WriteTimeout: time.Minute,
+			}
+			ln, err := listener.Listen(redirectAddress, unixSocketMode)
+			if err != nil {
+				log.Fatal().Err(err).Str("listenAddress", redirectAddress).Msg("failed to listen")
+			}
+			go func() {
+				if err := redirectServer.Serve(ln); err != nil {
+					log.Fatal().Err(err).Msg("error in redirectServer.Serve")
+				}
+			}()
+		}
+	}
+	httpServer = &fasthttp.Server{
 		Handler:                       h,
 		Name:                          "whats-this/cdn-origin v" + version,
 		ReadBufferSize:                1024 * 6, // 6 KB
 		ReadTimeout:                   time.Minute * 30,
 		WriteTimeout:                  time.Minute * 30,
-		GetOnly:                       true, // TODO: OPTIONS/HEAD requests
+		GetOnly:                       false, // routeRequest enforces allowed methods
 		DisableHeaderNamesNormalizing: false,
 	}
-	if err := server.ListenAndServe(listenAddress); err != nil {
-		log.Fatal().Err(err).Msg("error in server.ListenAndServe")
+	if concurrencyLimiter != nil {
+		httpServer.ConnState = releaseConcurrencySlot
+	}
+	var proxyProtocolSources []*net.IPNet
+	if viper.GetBool("http.proxyProtocol") {
+		proxyProtocolSources, err = clientip.ParseCIDRs(viper.GetStringSlice("http.proxyProtocolSources"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid http.proxyProtocolSources")
+		}
+	}
+	var tlsConf *tls.Config
+	if viper.GetBool("http.tls.enable") {
+		tlsConf = tlsConfig()
+	}
+
+	// All listeners are created before serving, so a bad address fails startup
+	// instead of leaving the server partially running
+	listenAddresses := viper.GetStringSlice("http.listenAddress")
+	listeners := make([]net.Listener, len(listenAddresses))
+	for i, listenAddress := range listenAddresses {
+		ln, err := listener.Listen(listenAddress, unixSocketMode)
+		if err != nil {
+			log.Fatal().Err(err).Str("listenAddress", listenAddress).Msg("failed to listen")
+		}
+		if proxyProtocolSources != nil {
+			ln = proxyproto.NewListener(ln, proxyProtocolSources, viper.GetDuration("http.proxyProtocolTimeout"))
+		}
+		if tlsConf != nil {
+			ln = tls.NewListener(ln, tlsConf)
+		}
+		if viper.GetBool("bandwidth.enable") {
+			ln = throttle.NewListener(ln)
+		}
+		listeners[i] = ln
+		log.Info().Str("listenAddress", listenAddress).Msg("Starting HTTP server")
+	}
+	if err := httpServer.Serve(listener.NewMulti(listeners...)); err != nil {
+		log.Fatal().Err(err).Msg("error in server.Serve")
 	}
 }
 
+// clientIP returns the IP address of the client. If http.trustProxy is enabled
+// and the peer is a trusted proxy, the address is resolved from the client IP
+// and forwarding headers. The result is cached on the request, so all
+// subsystems use the same address.
+func clientIP(ctx *fasthttp.RequestCtx) net.IP {
+	if ip, ok := ctx.UserValue("client_ip").(net.IP); ok {
+		return ip
+	}
+	ip := listener.PeerIP(ctx.RemoteAddr())
+	if clientIPResolver != nil {
+		ip = clientIPResolver.Resolve(ip, ctx.Request.Header.Peek)
+	}
+	ctx.SetUserValue("client_ip", ip)
+	return ip
+}
+
+// setRequestID assigns an ID to a request and creates a logger for it. The ID is
+// taken from the http.requestIDHeader request header if it is valid, and
+// generated otherwise. The ID is returned in the same response header.
+func setRequestID(ctx *fasthttp.RequestCtx) {
+	header := viper.GetString("http.requestIDHeader")
+	id := ""
+	if header != "" {
+		id = string(ctx.Request.Header.Peek(header))
+	}
+	if !validRequestID(id) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			log.Warn().Err(err).Msg("failed to generate request ID")
+		}
+		id = hex.EncodeToString(b)
+	}
+
+	logger := log.With().Str("request_id", id).Logger()
+	ctx.SetUserValue("request_id", id)
+	ctx.SetUserValue("logger", &logger)
+	if header != "" {
+		ctx.Response.Header.Set(header, id)
+	}
+}
+
+// validRequestID checks if an inbound request ID is non-empty, at most 128
+// characters long and only contains printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of a request, or an empty string if it has none.
+func requestID(ctx *fasthttp.RequestCtx) string {
+	id, _ := ctx.UserValue("request_id").(string)
+	return id
+}
+
+// requestLogger returns the logger for a request, which attaches the request
+// ID to every event. If the request has no logger, the global logger is
+// returned.
+func requestLogger(ctx *fasthttp.RequestCtx) *zerolog.Logger {
+	if logger, ok := ctx.UserValue("logger").(*zerolog.Logger); ok {
+		return logger
+	}
+	return &log.Logger
+}
+
+// thumbnailerHeaders returns the headers to forward to the thumbnailer service
+// for a request, including the traceparent of the outbound request's span. If
+// the request isn't sampled, the caller's traceparent is forwarded unchanged.
+func thumbnailerHeaders(ctx *fasthttp.RequestCtx, span *tracing.Span) map[string]string {
+	headers := map[string]string{}
+	if header := viper.GetString("http.requestIDHeader"); header != "" {
+		headers[header] = requestID(ctx)
+	}
+	if sc := span.Context(); sc.IsValid() {
+		headers["traceparent"] = sc.Traceparent()
+	} else if sc, ok := ctx.UserValue("traceparent").(tracing.SpanContext); ok {
+		headers["traceparent"] = sc.Traceparent()
+	}
+	return headers
+}
+
+// logAccess writes an access log entry for a request.
+func logAccess(ctx *fasthttp.RequestCtx, start time.Time) {
+	if accessLogger == nil {
+		return
+	}
+
+	remoteIP := ""
+	if ip := clientIP(ctx); ip != nil {
+		if viper.GetBool("accessLog.truncateIPs") {
+			ip = accesslog.TruncateIP(ip, viper.GetInt("accessLog.truncateIPv4Bits"), viper.GetInt("accessLog.truncateIPv6Bits"))
+		}
+		remoteIP = ip.String()
+	}
+	protocol := "HTTP/1.0"
+	if ctx.Request.Header.IsHTTP11() {
+		protocol = "HTTP/1.1"
+	}
+	bytes := int64(ctx.Response.Header.ContentLength())
+	if bytes < 0 {
+		bytes = int64(len(ctx.Response.Body()))
+	}
+
+	entry := &accesslog.Entry{
+		Time:       start,
+		RemoteIP:   remoteIP,
+		RequestID:  requestID(ctx),
+		Method:     string(ctx.Method()),
+		Host:       string(ctx.Host()),
+		Key:        strings.TrimPrefix(string(ctx.Path()), "/"),
+		Query:      string(ctx.QueryArgs().QueryString()),
+		Protocol:   protocol,
+		Status:     ctx.Response.StatusCode(),
+		Bytes:      bytes,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		Referer:    string(ctx.Referer()),
+		UserAgent:  string(ctx.UserAgent()),
+	}
+	if v, ok := ctx.UserValue("object_type").(string); ok {
+		entry.ObjectType = v
+	}
+	if v, ok := ctx.UserValue("cache_status").(string); ok {
+		entry.CacheStatus = v
+	}
+	if err := accessLogger.Log(entry); err != nil {
+		requestLogger(ctx).Warn().Err(err).Msg("failed to write access log entry")
+	}
+}
+
+// tlsConfig creates the TLS configuration for the HTTP server from the
+// http.tls configuration section. Certificates are selected by SNI and reloaded
+// when they change on disk.
+func tlsConfig() *tls.Config {
+	var pairs []certs.Pair
+	if err := viper.UnmarshalKey("http.tls.certificates", &pairs); err != nil {
+		log.Fatal().Err(err).Msg("failed to parse http.tls.certificates")
+	}
+	store, err := certs.NewStore(pairs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load TLS certificates")
+	}
+	store.Watch(viper.GetDuration("http.tls.reloadInterval"))
+
+	config := &tls.Config{
+		GetCertificate: store.GetCertificate,
+	}
+	config.MinVersion, err = certs.ParseVersion(viper.GetString("http.tls.minVersion"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid http.tls.minVersion")
+	}
+	if suites := viper.GetStringSlice("http.tls.cipherSuites"); len(suites) != 0 {
+		config.CipherSuites, err = certs.ParseCipherSuites(suites)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid http.tls.cipherSuites")
+		}
+	}
+	return config
+}
+
+// httpsRedirectHandler permanently redirects plain HTTP requests to HTTPS.
+func httpsRedirectHandler(ctx *fasthttp.RequestCtx) {
+	host := string(ctx.Host())
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if port := viper.GetString("http.tls.redirectPort"); port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	ctx.Redirect("https://"+host+string(ctx.RequestURI()), fasthttp.StatusMovedPermanently)
+}
+
 func recordMetrics(ctx *fasthttp.RequestCtx) {
 	if !viper.GetBool("metrics.enable") {
 		return
@@ -262,19 +943,17 @@ func recordMetrics(ctx *fasthttp.RequestCtx) {
 	}
 
 	// Determine remote IP
-	var remoteIP net.IP
-	if viper.GetBool("http.trustProxy") {
-		ipString := string(ctx.Request.Header.Peek("X-Forwarded-For"))
-		remoteIP = net.ParseIP(strings.Split(ipString, ",")[0])
-	} else {
-		remoteIP = ctx.RemoteIP()
-	}
+	remoteIP := clientIP(ctx)
+	logger := requestLogger(ctx)
 
 	// Anonymize host string and send record to Elasticsearch
 	hostBytes := ctx.Request.Header.Peek("Host")
 	statusCode := ctx.Response.StatusCode()
 	if len(hostBytes) != 0 {
+		span := startSpan(ctx, "metrics.record", tracing.KindClient)
 		go func() {
+			defer span.End()
+
 			// Check hostname
 			hostStr, isValid := collector.MatchHostname(string(hostBytes))
 			if !isValid {
@@ -285,7 +964,7 @@ func recordMetrics(ctx *fasthttp.RequestCtx) {
 			countryCode, err := collector.GetCountryCode(remoteIP)
 			if err != nil {
 				// Don't log the error here, it might contain an IP address
-				log.Warn().Msg("failed to get country code for IP, omitting from record")
+				logger.Warn().Msg("failed to get country code for IP, omitting from record")
 			}
 
 			record := metrics.GetRecord()
@@ -294,42 +973,155 @@ func recordMetrics(ctx *fasthttp.RequestCtx) {
 			record.ObjectType = objectType
 			record.StatusCode = statusCode
 			err = collector.Put(record)
+			span.SetError(err)
 			if err != nil {
-				log.Warn().Err(err).Msg("failed to collect record")
+				logger.Warn().Err(err).Msg("failed to collect record")
 				return
 			}
-			log.Debug().Msg("successfully collected metrics")
+			logger.Debug().Msg("successfully collected metrics")
 		}()
 	}
 }
 
+// setCacheControl sets the Cache-Control header of a response from the
+// configured policy for its class, unless the header has already been set. The
+// class is determined from the status code, the cache_class user value or the
+// object_type user value (in that order). Successful responses for objects
+// with an expiry time are never cached past it.
+func setCacheControl(ctx *fasthttp.RequestCtx) {
+	if len(ctx.Response.Header.Peek("Cache-Control")) != 0 {
+		return
+	}
+
+	var class string
+	switch ctx.Response.StatusCode() {
+	case fasthttp.StatusNotFound:
+		class = "notFound"
+	case fasthttp.StatusGone, fasthttp.StatusUnavailableForLegalReasons:
+		class = "gone"
+	case fasthttp.StatusOK, fasthttp.StatusFound, fasthttp.StatusNotModified:
+		if v, ok := ctx.UserValue("cache_class").(string); ok {
+			class = v
+		} else if v, ok := ctx.UserValue("object_type").(string); ok {
+			class = v
+		}
+		if expiresAt, ok := ctx.UserValue("expires_at").(time.Time); ok {
+			maxAge := int(time.Until(expiresAt).Seconds())
+			if maxAge < 0 {
+				maxAge = 0
+			}
+			ctx.Response.Header.Set("Cache-Control", cacheControlTable.GetExpiring(class, maxAge))
+			return
+		}
+	}
+	if v, ok := cacheControlTable.Get(class); ok {
+		ctx.Response.Header.Set("Cache-Control", v)
+	}
+}
+
+// routeRequest routes requests to reserved endpoints or to requestHandler.
+// Reserved endpoints take precedence over objects with the same key.
+func routeRequest(ctx *fasthttp.RequestCtx) {
+	atomic.AddInt64(&inFlightRequests, 1)
+	defer atomic.AddInt64(&inFlightRequests, -1)
+	defer logAccess(ctx, time.Now())
+	setRequestID(ctx)
+	resetThrottle(ctx)
+	span := startRequestSpan(ctx)
+	defer endRequestSpan(ctx, span)
+
+	path := string(ctx.Path())
+	switch {
+	case path == viper.GetString("http.healthPath") && ctx.IsGet():
+		livenessHandler(ctx)
+	case path == viper.GetString("http.readyPath") && ctx.IsGet():
+		readinessHandler(ctx)
+	case objectPurger != nil && path == purgePath:
+		purgeRequestHandler(ctx)
+	case !ctx.IsGet() && !(ctx.IsPost() && viper.GetBool("passwords.enable")):
+		methodNotAllowed(ctx)
+	case !allowRequest(ctx):
+		// Rate limited
+	case ctx.IsPost():
+		unlockHandler(ctx)
+	default:
+		requestHandler(ctx)
+	}
+}
+
 func requestHandler(ctx *fasthttp.RequestCtx) {
 	defer recordMetrics(ctx)
+	defer setCacheControl(ctx)
+
+	// Signed URLs are checked before anything else, so rejected requests don't
+	// reveal whether an object exists
+	bucket := viper.GetString("database.objectBucket")
+	if !checkSignature(ctx, bucket) {
+		return
+	}
+
+	// Content-addressed files
+	if viper.GetBool("files.enableHashRoute") && strings.HasPrefix(string(ctx.Path()), hashRoutePrefix) {
+		hashRequestHandler(ctx)
+		return
+	}
 
 	// Fetch object from database
 	key := string(ctx.Path()[1:])
-	object, err := db.SelectObjectByBucketKey(viper.GetString("database.objectBucket"), key)
+	object, err := selectObjectByBucketKey(ctx, bucket, key)
 	switch {
 	case err == sql.ErrNoRows:
+		setSurrogateKeys(ctx, "key:"+bucket+"/"+key)
 		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		ctx.SetContentType("text/plain; charset=utf8")
 		fmt.Fprintf(ctx, "404 Not Found: %s", ctx.Path())
 		return
 	case err != nil:
-		log.Error().Err(err).Msg("failed to run SELECT query on database")
+		requestLogger(ctx).Error().Err(err).Msg("failed to run SELECT query on database")
 		internalServerError(ctx)
 		return
 	}
+	setSurrogateKeys(ctx, objectSurrogateKeys(object)...)
+	deleteObject(ctx, &object)
+	expireObject(ctx, &object)
+
+	// Blocked files aren't served in any form, including their metadata
+	if object.ObjectType == 0 && !checkBlocklist(ctx, object) {
+		return
+	}
+
+	// Password-protected objects, including their metadata and thumbnails, are
+	// only served after unlocking
+	if object.ObjectType != 2 && !checkPassword(ctx, bucket, key, object) {
+		return
+	}
+
+	// Object metadata
+	if viper.GetBool("http.objectInfoAcceptJSON") {
+		ctx.Response.Header.Set("Vary", "Accept")
+	}
+	if wantsObjectInfo(ctx) {
+		objectInfoHandler(ctx, key, object)
+		return
+	}
 
 	switch object.ObjectType {
 	case 0: // file
 		ctx.SetUserValue("object_type", "file")
 		if object.SHA256Hash == nil {
-			log.Warn().Str("key", key).Msg("encountered file object with NULL sha256_hash")
+			requestLogger(ctx).Warn().Str("key", key).Msg("encountered file object with NULL sha256_hash")
 			internalServerError(ctx)
 			return
 		}
 		fPath := filepath.Join(viper.GetString("files.storageLocation"), *object.SHA256Hash)
+		contentType := fileContentType(ctx, object, fPath)
+		if ctx.QueryArgs().Has("preview") {
+			filePreviewHandler(ctx, key, object, contentType)
+			return
+		}
+		if !checkHotlink(ctx, bucket, key) {
+			return
+		}
 		ifNoneMatch := string(ctx.Request.Header.Peek("If-None-Match"))
 		if len(ifNoneMatch) > 2 {
 			ifNoneMatch = ifNoneMatch[1 : len(ifNoneMatch)-1]
@@ -337,8 +1129,9 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 
 		// Thumbnails
 		if viper.GetBool("thumbnails.enable") && ctx.QueryArgs().Has("thumbnail") {
+			ctx.SetUserValue("cache_class", "thumbnail")
 			thumbnailKey := *object.SHA256Hash
-			if !thumbnailer.AcceptedMIMEType(*object.ContentType) {
+			if !thumbnailer.AcceptedMIMEType(contentType) {
 				ctx.SetStatusCode(fasthttp.StatusNotFound)
 				ctx.SetContentType("text/plain; charset=utf8")
 				fmt.Fprintf(ctx, "404 Not Found: %s?thumbnail (cannot generate thumbnail)", ctx.Path())
@@ -355,63 +1148,65 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 			// TODO: refactor this
 			var thumb io.ReadCloser
 			if viper.GetBool("thumbnails.cacheEnable") {
-				thumb, err = thumbnailCache.GetThumbnail(thumbnailKey)
+				thumb, err = getCachedThumbnail(ctx, thumbnailKey)
 				if thumb != nil {
 					defer thumb.Close()
 				}
+				ctx.SetUserValue("cache_status", "hit")
 				if err == thumbnailer.NoCachedCopy {
-					file, err := os.Open(fPath)
+					ctx.SetUserValue("cache_status", "miss")
+					file, err := openFile(ctx, fPath)
 					if file != nil {
 						defer file.Close()
 					}
 					if err != nil {
-						log.Warn().Err(err).Msg("failed to open original file to generate thumbnail")
+						requestLogger(ctx).Warn().Err(err).Msg("failed to open original file to generate thumbnail")
 						internalServerError(ctx)
 						return
 					}
-					err = thumbnailCache.Transform(thumbnailKey, *object.ContentType, file)
+					err = transformCachedThumbnail(ctx, thumbnailKey, contentType, file)
 					if err == thumbnailer.InputTooLarge {
 						ctx.SetStatusCode(fasthttp.StatusNotFound)
 						ctx.SetContentType("text/plain; charset=utf8")
 						fmt.Fprintf(ctx, "404 Not Found: %s?thumbnail (cannot generate thumbnail)", ctx.Path())
 						return
 					} else if err != nil {
-						log.Warn().Err(err).Msg("failed to generate new thumbnail")
+						requestLogger(ctx).Warn().Err(err).Msg("failed to generate new thumbnail")
 						internalServerError(ctx)
 						return
 					}
-					thumb, err = thumbnailCache.GetThumbnail(thumbnailKey)
+					thumb, err = getCachedThumbnail(ctx, thumbnailKey)
 					if thumb != nil {
 						defer thumb.Close()
 					}
 					if err != nil {
-						log.Warn().Err(err).Msg("failed to get thumbnail from cache")
+						requestLogger(ctx).Warn().Err(err).Msg("failed to get thumbnail from cache")
 						internalServerError(ctx)
 						return
 					}
 				} else if err != nil {
-					log.Warn().Err(err).Msg("failed to get thumbnail from cache")
+					requestLogger(ctx).Warn().Err(err).Msg("failed to get thumbnail from cache")
 					internalServerError(ctx)
 					return
 				}
 			} else {
-				file, err := os.Open(fPath)
+				file, err := openFile(ctx, fPath)
 				if file != nil {
 					defer file.Close()
 				}
 				if err != nil {
-					log.Warn().Err(err).Msg("failed to open original file to generate thumbnail")
+					requestLogger(ctx).Warn().Err(err).Msg("failed to open original file to generate thumbnail")
 					internalServerError(ctx)
 					return
 				}
-				thumbR, err := thumbnailer.Transform(viper.GetString("thumbnails.thumbnailerURL"), *object.ContentType, file)
+				thumbR, err := transformThumbnail(ctx, contentType, file)
 				if err == thumbnailer.InputTooLarge {
 					ctx.SetStatusCode(fasthttp.StatusNotFound)
 					ctx.SetContentType("text/plain; charset=utf8")
 					fmt.Fprintf(ctx, "404 Not Found: %s?thumbnail (cannot generate thumbnail)", ctx.Path())
 					return
 				} else if err != nil {
-					log.Warn().Err(err).Msg("failed to generate new thumbnail")
+					requestLogger(ctx).Warn().Err(err).Msg("failed to generate new thumbnail")
 					internalServerError(ctx)
 					return
 				}
@@ -420,13 +1215,16 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 			}
 
 			// Send response
+			if !takeView(ctx, bucket, key, &object, false) {
+				return
+			}
 			ctx.SetStatusCode(fasthttp.StatusOK)
 			ctx.SetContentType("image/jpeg")
-			ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`filename="%s.thumbnail.jpeg"`, key))
+			ctx.Response.Header.Set("Content-Disposition", contentDisposition("inline", objectFilename(object, key)+".thumbnail.jpeg"))
 			ctx.Response.Header.Set("ETag", fmt.Sprintf(`"%s-thumb"`, *object.SHA256Hash))
 			_, err = io.Copy(ctx, thumb)
 			if err != nil {
-				log.Warn().Err(err).Msg("failed to send thumbnail response")
+				requestLogger(ctx).Warn().Err(err).Msg("failed to send thumbnail response")
 				ctx.Response.Header.Del("Content-Disposition")
 				internalServerError(ctx)
 				return
@@ -436,10 +1234,10 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 
 		// Discord workaround. They're hiding direct image embeds, so we
 		// serve HTML pages with metadata showing the image.
-		if object.ContentType != nil && discordBotRegex.Match(ctx.Request.Header.UserAgent()) && !ctx.QueryArgs().Has(rawParam) {
-			typ, _, err := mime.ParseMediaType(*object.ContentType)
+		if discordBotRegex.Match(ctx.Request.Header.UserAgent()) && !ctx.QueryArgs().Has(rawParam) {
+			typ, _, err := mime.ParseMediaType(contentType)
 			if err != nil {
-				log.Warn().Err(err).Msg("failed to parse content-type of file")
+				requestLogger(ctx).Warn().Err(err).Msg("failed to parse content-type of file")
 				internalServerError(ctx)
 				return
 			}
@@ -456,6 +1254,9 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 				}
 
 				url := fmt.Sprintf("%v://%s%s?%v=true", scheme, ctx.Request.Header.Peek("Host"), ctx.Path(), rawParam)
+				if q := signedQuery(ctx); q != "" {
+					url += "&" + q
+				}
 
 				// Make it so CloudFlare won't cache it.
 				ctx.SetStatusCode(fasthttp.StatusOK)
@@ -465,7 +1266,7 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 				ctx.Response.Header.Add("Expires", "0")
 				err = discordHTMLTemplate.Execute(ctx, url)
 				if err != nil {
-					log.Warn().Err(err).Msg("failed to execute discord html template on discordbot connection")
+					requestLogger(ctx).Warn().Err(err).Msg("failed to execute discord html template on discordbot connection")
 					internalServerError(ctx)
 					return
 				}
@@ -480,23 +1281,26 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 		}
 
 		// Serve file to client
-		ctx.SetStatusCode(fasthttp.StatusOK)
-		if object.ContentType != nil {
-			ctx.SetContentType(*object.ContentType)
-		} else {
-			ctx.SetContentType("application/octet-stream")
+		if !takeView(ctx, bucket, key, &object, true) {
+			return
 		}
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		setUserContentHeaders(ctx, contentType, objectFilename(object, key))
 		ctx.Response.Header.Set("ETag", fmt.Sprintf(`"%s"`, *object.SHA256Hash))
-		fasthttp.ServeFileUncompressed(ctx, fPath)
+		serveFile(ctx, fPath)
+		throttleResponse(ctx)
 
 	case 1: // redirect
 		ctx.SetUserValue("object_type", "redirect")
 
 		if object.DestURL == nil {
-			log.Warn().Str("key", key).Msg("encountered redirect object with NULL dest_url")
+			requestLogger(ctx).Warn().Str("key", key).Msg("encountered redirect object with NULL dest_url")
 			internalServerError(ctx)
 			return
 		}
+		if !takeView(ctx, bucket, key, &object, false) {
+			return
+		}
 
 		previewMode := ctx.QueryArgs().Has("preview")
 		var err error
@@ -506,7 +1310,7 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 			err = redirectHTMLTemplate.Execute(ctx, object.DestURL)
 		}
 		if err != nil {
-			log.Warn().Err(err).
+			requestLogger(ctx).Warn().Err(err).
 				Str("dest_url", *object.DestURL).
 				Bool("preview", ctx.QueryArgs().Has("preview")).
 				Msg("failed to generate HTML redirect page to send to client")
@@ -524,17 +1328,353 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 		}
 
 	case 2: // tombstone
-		ctx.SetUserValue("object_type", "tombstone")
+		tombstoneHandler(ctx, object)
+	}
+}
+
+// tombstoneHandler sends a 410 Gone response with the deletion reason of an
+// object.
+func tombstoneHandler(ctx *fasthttp.RequestCtx, object db.Object) {
+	ctx.SetUserValue("object_type", "tombstone")
+	ctx.SetStatusCode(fasthttp.StatusGone)
+	ctx.SetContentType("text/plain; charset=utf8")
+	reason := "no reason specified"
+	if object.DeleteReason != nil && *object.DeleteReason != "" {
+		reason = *object.DeleteReason
+	}
+	fmt.Fprintf(ctx, "410 Gone: %s\n\nReason: %s", ctx.Path(), reason)
+}
+
+// wantsObjectInfo returns true if the client requested object metadata instead
+// of the object itself, either with the info query parameter or (if enabled)
+// by preferring application/json in the Accept header.
+func wantsObjectInfo(ctx *fasthttp.RequestCtx) bool {
+	if ctx.QueryArgs().Has(infoParam) {
+		return true
+	}
+	if !viper.GetBool("http.objectInfoAcceptJSON") {
+		return false
+	}
+	accept := strings.SplitN(string(ctx.Request.Header.Peek("Accept")), ",", 2)[0]
+	typ, _, err := mime.ParseMediaType(accept)
+	return err == nil && typ == "application/json"
+}
+
+// objectInfoHandler sends the metadata of an object to the client as JSON.
+// Content details are omitted for tombstones.
+func objectInfoHandler(ctx *fasthttp.RequestCtx, key string, object db.Object) {
+	typ, ok := objectTypes[object.ObjectType]
+	if !ok {
+		typ = "unknown"
+	}
+	ctx.SetUserValue("object_type", typ)
+	ctx.SetUserValue("cache_class", "info")
+
+	info := objectInfo{
+		Key:       key,
+		Type:      typ,
+		CreatedAt: object.CreatedAt,
+	}
+	if object.ObjectType != 2 {
+		info.ExpiresAt = object.ExpiresAt
+		info.ViewsLeft = object.MaxViews
+	}
+	switch object.ObjectType {
+	case 0: // file
+		info.ContentType = object.ContentType
+		info.ContentLength = object.ContentLength
+		info.MD5Hash = object.MD5Hash
+		info.SHA256Hash = object.SHA256Hash
+	case 1: // redirect
+		info.DestURL = object.DestURL
+	case 2: // tombstone
+		info.DeletedAt = object.DeletedAt
+		info.DeleteReason = object.DeleteReason
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json; charset=utf8")
+	err := json.NewEncoder(ctx).Encode(info)
+	if err != nil {
+		requestLogger(ctx).Warn().Err(err).Msg("failed to encode object info response")
+		ctx.ResetBody()
+		internalServerError(ctx)
+	}
+}
+
+// filePreviewHandler sends an HTML page embedding a file object, which is
+// also where hotlinked requests can be redirected to.
+func filePreviewHandler(ctx *fasthttp.RequestCtx, key string, object db.Object, contentType string) {
+	typ, _, _ := mime.ParseMediaType(contentType)
+	fileURL := &url.URL{Path: "/" + key, RawQuery: signedQuery(ctx)}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("text/html; charset=utf8")
+	err := filePreviewHTMLTemplate.Execute(ctx, struct {
+		Name  string
+		URL   string
+		Image bool
+	}{
+		Name:  objectFilename(object, key),
+		URL:   fileURL.String(),
+		Image: strings.HasPrefix(typ, "image/"),
+	})
+	if err != nil {
+		requestLogger(ctx).Warn().Err(err).Msg("failed to execute file preview html template")
+		internalServerError(ctx)
+	}
+}
+
+// hashRequestHandler serves a file directly by its SHA256 hash, as long as at
+// least one non-deleted file object in the bucket references it. As the
+// content of the URL can never change, responses are marked as immutable.
+func hashRequestHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetUserValue("object_type", "file")
+	ctx.SetUserValue("cache_class", "hash")
+
+	hash := strings.ToLower(strings.TrimPrefix(string(ctx.Path()), hashRoutePrefix))
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != sha256.Size {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprintf(ctx, "404 Not Found: %s", ctx.Path())
+		return
+	}
 
-		// Send 410 gone response
-		ctx.SetStatusCode(fasthttp.StatusGone)
+	// Fetch object from database
+	object, err := selectFileObjectBySHA256Hash(ctx, viper.GetString("database.objectBucket"), hashBytes)
+	setSurrogateKeys(ctx, "sha256:"+hash)
+	switch {
+	case err == sql.ErrNoRows:
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		ctx.SetContentType("text/plain; charset=utf8")
-		reason := "no reason specified"
-		if object.DeleteReason != nil && *object.DeleteReason != "" {
-			reason = *object.DeleteReason
+		fmt.Fprintf(ctx, "404 Not Found: %s", ctx.Path())
+		return
+	case err != nil:
+		requestLogger(ctx).Error().Err(err).Msg("failed to run SELECT query on database")
+		internalServerError(ctx)
+		return
+	}
+	if !checkBlocklist(ctx, object) {
+		return
+	}
+	if !checkHotlink(ctx, viper.GetString("database.objectBucket"), object.Key) {
+		return
+	}
+
+	// Check for If-None-Match header
+	ctx.Response.Header.Set("ETag", fmt.Sprintf(`"%s"`, hash))
+	ifNoneMatch := string(ctx.Request.Header.Peek("If-None-Match"))
+	if len(ifNoneMatch) > 2 {
+		ifNoneMatch = ifNoneMatch[1 : len(ifNoneMatch)-1]
+	}
+	if ifNoneMatch == hash {
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+		return
+	}
+
+	// Serve file to client
+	fPath := filepath.Join(viper.GetString("files.storageLocation"), hash)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	setUserContentHeaders(ctx, fileContentType(ctx, object, fPath), "")
+	serveFile(ctx, fPath)
+	throttleResponse(ctx)
+}
+
+// fileContentType returns the content type to serve a file object with. If
+// content sniffing is enabled and the stored content type is missing or
+// generic, the content type is detected from the stored file instead. Sniffed
+// types never replace the stored type with a risky one.
+func fileContentType(ctx *fasthttp.RequestCtx, object db.Object, fPath string) string {
+	typ := "application/octet-stream"
+	if object.ContentType != nil && *object.ContentType != "" {
+		typ = *object.ContentType
+	}
+	if contentSniffer == nil || object.SHA256Hash == nil {
+		return typ
+	}
+	if _, ok := sniffGenericTypes[strings.ToLower(strings.SplitN(typ, ";", 2)[0])]; !ok {
+		return typ
+	}
+
+	sniffed, err := contentSniffer.Sniff(*object.SHA256Hash, fPath)
+	if err != nil {
+		requestLogger(ctx).Warn().Err(err).Str("sha256_hash", *object.SHA256Hash).Msg("failed to sniff content type of file")
+		return typ
+	}
+	if sniffed == "" || (contentPolicy != nil && contentPolicy.IsRisky(sniffed)) {
+		return typ
+	}
+	return sniffed
+}
+
+// setUserContentHeaders sets the Content-Type and Content-Disposition headers of
+// a response containing user content. If the content security policy is
+// enabled, risky content types are replaced or served as attachments and
+// hardening headers are added. The filename may be empty.
+func setUserContentHeaders(ctx *fasthttp.RequestCtx, typ, filename string) {
+	disposition := "inline"
+	if ctx.QueryArgs().Has(downloadParam) {
+		disposition = "attachment"
+	}
+
+	if contentPolicy != nil {
+		if viper.GetBool("security.noSniff") {
+			ctx.Response.Header.Set("X-Content-Type-Options", "nosniff")
+		}
+		if csp := viper.GetString("security.contentSecurityPolicy"); csp != "" {
+			ctx.Response.Header.Set("Content-Security-Policy", csp)
+		}
+		switch contentPolicy.Action(typ) {
+		case contentpolicy.PlainText:
+			typ = "text/plain; charset=utf8"
+		case contentpolicy.Attachment:
+			disposition = "attachment"
 		}
-		fmt.Fprintf(ctx, "410 Gone: %s\n\nReason: %s", ctx.Path(), reason)
 	}
+	ctx.SetContentType(typ)
+
+	if !viper.GetBool("files.contentDisposition") {
+		filename = ""
+	}
+	if disposition != "inline" || filename != "" {
+		ctx.Response.Header.Set("Content-Disposition", contentDisposition(disposition, filename))
+	}
+}
+
+// objectFilename returns the filename of an object, which is the object's key
+// without its directory. If the object has no key, the last element of the
+// request key is used instead.
+func objectFilename(object db.Object, key string) string {
+	if object.Key != "" && strings.HasPrefix(object.Key, object.Dir) {
+		if name := object.Key[len(object.Dir):]; name != "" {
+			return name
+		}
+	}
+	return path.Base(key)
+}
+
+// contentDisposition returns a Content-Disposition header value with the
+// specified disposition type and filename. The filename is encoded as described
+// in RFC 6266, with an ASCII fallback and an RFC 5987 encoded UTF-8 version.
+func contentDisposition(disposition, filename string) string {
+	if filename == "" {
+		return disposition
+	}
+
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, filename)
+	value := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback)
+	if fallback != filename {
+		var encoded strings.Builder
+		for _, b := range []byte(filename) {
+			if isRFC5987AttrChar(b) {
+				encoded.WriteByte(b)
+			} else {
+				fmt.Fprintf(&encoded, "%%%02X", b)
+			}
+		}
+		value += "; filename*=UTF-8''" + encoded.String()
+	}
+	return value
+}
+
+// isRFC5987AttrChar returns true if the byte is an attr-char as defined in RFC
+// 5987, which don't have to be percent-encoded.
+func isRFC5987AttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) != -1
+}
+
+// objectSurrogateKeys returns the surrogate keys for an object: its bucket key,
+// its SHA256 hash and its associated user (if set).
+func objectSurrogateKeys(object db.Object) []string {
+	keys := []string{"key:" + object.BucketKey}
+	if object.SHA256Hash != nil {
+		keys = append(keys, "sha256:"+*object.SHA256Hash)
+	}
+	if object.AssociatedUser != nil {
+		keys = append(keys, "user:"+*object.AssociatedUser)
+	}
+	return keys
+}
+
+// setSurrogateKeys tags a response with surrogate keys in each configured
+// surrogate key header, so CDNs can purge responses by key. Keys are escaped
+// and prefixed with surrogateKeys.prefix. Cache-Tag headers are
+// comma-separated, all other headers are space-separated.
+func setSurrogateKeys(ctx *fasthttp.RequestCtx, keys ...string) {
+	if !viper.GetBool("surrogateKeys.enable") || len(keys) == 0 {
+		return
+	}
+
+	prefix := viper.GetString("surrogateKeys.prefix")
+	escaped := make([]string, len(keys))
+	for i, k := range keys {
+		escaped[i] = url.QueryEscape(prefix + k)
+	}
+	for _, header := range viper.GetStringSlice("surrogateKeys.headers") {
+		sep := " "
+		if strings.EqualFold(header, "Cache-Tag") {
+			sep = ","
+		}
+		ctx.Response.Header.Set(header, strings.Join(escaped, sep))
+	}
+}
+
+// purgeRequestHandler queues objects to be purged from the CDN. Requests must
+// be POST requests authenticated with purger.endpointToken as a bearer token,
+// and objects are specified by key with one or more key query parameters.
+func purgeRequestHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set("Allow", "POST")
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "405 Method Not Allowed")
+		return
+	}
+	token := viper.GetString("purger.endpointToken")
+	auth := ctx.Request.Header.Peek("Authorization")
+	if token == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+		ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "401 Unauthorized")
+		return
+	}
+
+	keys := ctx.QueryArgs().PeekMulti("key")
+	if len(keys) == 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "400 Bad Request: at least one key is required")
+		return
+	}
+	for _, key := range keys {
+		if !objectPurger.Enqueue(string(key)) {
+			requestLogger(ctx).Warn().Str("key", string(key)).Msg("purge queue is full, dropping purge request")
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			ctx.SetContentType("text/plain; charset=utf8")
+			fmt.Fprint(ctx, "503 Service Unavailable: purge queue is full")
+			return
+		}
+	}
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+	ctx.SetContentType("text/plain; charset=utf8")
+	fmt.Fprintf(ctx, "202 Accepted: %d key(s) queued for purging", len(keys))
+}
+
+// methodNotAllowed sends a 405 Method Not Allowed response.
+func methodNotAllowed(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+	ctx.Response.Header.Set("Allow", "GET")
+	ctx.SetContentType("text/plain; charset=utf8")
+	fmt.Fprint(ctx, "405 Method Not Allowed")
 }
 
 // internalServerError returns a 500 Internal Server Response.
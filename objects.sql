@@ -16,6 +16,23 @@ CREATE TABLE IF NOT EXISTS objects (
   md5_hash VARCHAR(32) DEFAULT NULL -- MD5 hash of file contents (or destination URL)
 );
 
+-- Optional: password-protected objects (see `passwords.enable`). Values are
+-- bcrypt hashes, objects with a NULL hash are public.
+-- ALTER TABLE objects ADD COLUMN password_hash VARCHAR(60) DEFAULT NULL;
+
+-- Optional: expiring and view-limited objects. Expired objects and objects
+-- with no views left are served as tombstones.
+-- ALTER TABLE objects ADD COLUMN expires_at TIMESTAMP DEFAULT NULL;
+-- ALTER TABLE objects ADD COLUMN max_views INT DEFAULT NULL;
+
+-- Optional: hash blocklist (see `blocklist.database`). Hashes are raw MD5 (16
+-- bytes) or SHA256 (32 bytes) digests.
+-- CREATE TABLE IF NOT EXISTS blocked_hashes (
+--   hash BYTEA NOT NULL PRIMARY KEY,
+--   reason VARCHAR(256) DEFAULT NULL,
+--   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+-- );
+
 -- Test file object: /index.md
 INSERT INTO objects (bucket_key, bucket, key, dir, type, content_type, content_length, md5_hash) VALUES (
   'public/index.txt',
@@ -38,3 +55,19 @@ INSERT INTO objects (bucket_key, bucket, key, dir, type, dest_url, content_type)
   'https://google.com',
   NULL
 );
+
+-- Optional: notify cdn-origin of changed objects so they can be purged from the
+-- CDN (see `purger.listenChannel`). Only updates to columns that affect served
+-- content fire the trigger, so view counting doesn't cause purges. Add
+-- password_hash and expires_at to the column list if they exist.
+-- CREATE OR REPLACE FUNCTION notify_object_change() RETURNS trigger AS $$
+-- BEGIN
+--   PERFORM pg_notify('object_changes', OLD.bucket_key);
+--   RETURN NULL;
+-- END;
+-- $$ LANGUAGE plpgsql;
+--
+-- DROP TRIGGER IF EXISTS objects_notify_change ON objects;
+-- CREATE TRIGGER objects_notify_change
+--   AFTER UPDATE OF "type", dest_url, content_type, content_length, deleted_at, delete_reason, md5_hash OR DELETE ON objects
+--   FOR EACH ROW EXECUTE PROCEDURE notify_object_change();
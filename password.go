@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"owo.codes/whats-this/cdn-origin/lib/db"
+	"owo.codes/whats-this/cdn-origin/lib/signedurl"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordField is the name of the password field in the unlock form.
+const passwordField = "password"
+
+// unlockSigner signs the cookies set after unlocking a password-protected
+// object. It is nil if password-protected objects can't be unlocked.
+var unlockSigner *signedurl.Signer
+
+// checkPassword ensures the client has unlocked a password-protected object.
+// If it hasn't, the unlock form is sent and false is returned. Unlocked
+// responses are never cached publicly.
+func checkPassword(ctx *fasthttp.RequestCtx, bucket, key string, object db.Object) bool {
+	if object.PasswordHash == nil {
+		return true
+	}
+	ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	addVary(ctx, "Cookie")
+	if unlockSigner == nil {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "403 Forbidden: this object is password protected")
+		return false
+	}
+	if validUnlockCookie(ctx, bucket, key, *object.PasswordHash) {
+		return true
+	}
+	sendUnlockForm(ctx, false)
+	return false
+}
+
+// unlockHandler handles unlock form submissions. If the password is correct, a
+// cookie unlocking the object is set and the client is redirected back to the
+// object. Deleted, expired and blocked objects get the same response as they
+// would for a GET request, so they can't be unlocked.
+func unlockHandler(ctx *fasthttp.RequestCtx) {
+	bucket := viper.GetString("database.objectBucket")
+	if !checkSignature(ctx, bucket) {
+		return
+	}
+	key := string(ctx.Path()[1:])
+	object, err := selectObjectByBucketKey(ctx, bucket, key)
+	switch {
+	case err == sql.ErrNoRows:
+		methodNotAllowed(ctx)
+		return
+	case err != nil:
+		requestLogger(ctx).Error().Err(err).Msg("failed to run SELECT query on database")
+		internalServerError(ctx)
+		return
+	}
+	deleteObject(ctx, &object)
+	expireObject(ctx, &object)
+	if object.ObjectType == 0 && !checkBlocklist(ctx, object) {
+		return
+	}
+	if object.ObjectType == 2 {
+		tombstoneHandler(ctx, object)
+		return
+	}
+	if object.PasswordHash == nil {
+		methodNotAllowed(ctx)
+		return
+	}
+	ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	if unlockSigner == nil {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "403 Forbidden: this object is password protected")
+		return
+	}
+
+	password := ctx.PostArgs().Peek(passwordField)
+	if bcrypt.CompareHashAndPassword([]byte(*object.PasswordHash), password) != nil {
+		requestLogger(ctx).Debug().Str("key", key).Msg("incorrect password for object")
+		sendUnlockForm(ctx, true)
+		return
+	}
+
+	ttl := viper.GetDuration("passwords.cookieTTL")
+	expires := time.Now().Add(ttl)
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+	c.SetKey(unlockCookieName(key))
+	c.SetValue(unlockCookieValue(bucket, key, *object.PasswordHash, expires))
+	c.SetPath("/" + key)
+	c.SetMaxAge(int(ttl.Seconds()))
+	c.SetHTTPOnly(true)
+	c.SetSecure(ctx.IsTLS() || viper.GetBool("passwords.secureCookie"))
+	c.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	ctx.Response.Header.SetCookie(c)
+	ctx.Redirect(string(ctx.RequestURI()), fasthttp.StatusSeeOther)
+}
+
+// sendUnlockForm sends the HTML password form for a protected object.
+func sendUnlockForm(ctx *fasthttp.RequestCtx, failed bool) {
+	ctx.SetStatusCode(fasthttp.StatusForbidden)
+	ctx.SetContentType("text/html; charset=utf8")
+	ctx.Response.Header.Set("X-Frame-Options", "DENY")
+	if err := unlockHTMLTemplate.Execute(ctx, failed); err != nil {
+		requestLogger(ctx).Warn().Err(err).Msg("failed to execute unlock html template")
+		ctx.ResetBody()
+		internalServerError(ctx)
+	}
+}
+
+// unlockCookieName returns the name of the unlock cookie for a key. Names are
+// unique per key, so cookies for nested paths don't shadow each other.
+func unlockCookieName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "unlock_" + hex.EncodeToString(sum[:8])
+}
+
+// unlockCookieValue returns a signed unlock cookie value for a key. The
+// signature covers the password hash, so changing the password invalidates
+// existing cookies.
+func unlockCookieValue(bucket, key, passwordHash string, expires time.Time) string {
+	sig := unlockSigner.Sign(bucket, "/"+key+"\n"+passwordHash, expires)
+	return strconv.FormatInt(expires.Unix(), 10) + "." + sig
+}
+
+// validUnlockCookie returns true if the request has a valid unlock cookie for a
+// key.
+func validUnlockCookie(ctx *fasthttp.RequestCtx, bucket, key, passwordHash string) bool {
+	value := string(ctx.Request.Header.Cookie(unlockCookieName(key)))
+	i := strings.IndexByte(value, '.')
+	if i == -1 {
+		return false
+	}
+	err := unlockSigner.Verify(bucket, "/"+key+"\n"+passwordHash, value[:i], value[i+1:], time.Now())
+	return err == nil
+}
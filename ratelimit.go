@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+
+	"owo.codes/whats-this/cdn-origin/lib/clientip"
+	"owo.codes/whats-this/cdn-origin/lib/listener"
+	"owo.codes/whats-this/cdn-origin/lib/ratelimit"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+var fetchLimiter *ratelimit.Limiter
+var thumbnailLimiter *ratelimit.Limiter
+var concurrencyLimiter *ratelimit.Concurrency
+var rateLimitAllowlist []*net.IPNet
+
+// concurrencySlots maps connections to the concurrency key of their peer
+// address. Slots are released from the server's ConnState hook once the
+// connection is closed or hijacked, so idle keep-alive connections and slow
+// downloads keep holding their slot.
+var concurrencySlots sync.Map
+
+// setupRateLimit creates the rate limiters from the rateLimit configuration.
+func setupRateLimit() error {
+	var err error
+	rateLimitAllowlist, err = clientip.ParseCIDRs(viper.GetStringSlice("rateLimit.allowlist"))
+	if err != nil {
+		return fmt.Errorf("invalid rateLimit.allowlist: %s", err)
+	}
+	if rate := viper.GetFloat64("rateLimit.fetch.rate"); rate > 0 {
+		fetchLimiter = ratelimit.New(rate, viper.GetInt("rateLimit.fetch.burst"))
+	}
+	if rate := viper.GetFloat64("rateLimit.thumbnail.rate"); rate > 0 {
+		thumbnailLimiter = ratelimit.New(rate, viper.GetInt("rateLimit.thumbnail.burst"))
+	}
+	if max := viper.GetInt("rateLimit.maxConnections"); max > 0 {
+		concurrencyLimiter = ratelimit.NewConcurrency(max)
+	}
+	return nil
+}
+
+// allowRequest applies the rate limits to a request. If the client is over a
+// limit, a 429 Too Many Requests response is sent and false is returned.
+// Thumbnail requests use the thumbnail budget instead of the fetch budget.
+func allowRequest(ctx *fasthttp.RequestCtx) bool {
+	if !viper.GetBool("rateLimit.enable") {
+		return true
+	}
+	if !acquireConnectionSlot(ctx) {
+		return false
+	}
+	ip := clientIP(ctx)
+	if clientip.Contains(rateLimitAllowlist, ip) {
+		return true
+	}
+	key := ratelimit.Key(ip, viper.GetInt("rateLimit.subnetIPv4Bits"), viper.GetInt("rateLimit.subnetIPv6Bits"))
+
+	limiter := fetchLimiter
+	if viper.GetBool("thumbnails.enable") && ctx.QueryArgs().Has("thumbnail") {
+		limiter = thumbnailLimiter
+	}
+	if limiter != nil {
+		if ok, retryAfter := limiter.Allow(key); !ok {
+			tooManyRequests(ctx, int(math.Ceil(retryAfter.Seconds())))
+			return false
+		}
+	}
+	return true
+}
+
+// acquireConnectionSlot caps the number of open connections per peer address.
+// The slot is taken on a connection's first request rather than when it is
+// accepted, as the peer address of PROXY protocol connections is only known
+// once the header has been read. Trusted proxies are exempt, as their
+// connections carry requests from many clients. If the peer is at its cap, a
+// 429 Too Many Requests response is sent, the connection is closed and false
+// is returned.
+func acquireConnectionSlot(ctx *fasthttp.RequestCtx) bool {
+	if concurrencyLimiter == nil {
+		return true
+	}
+	if _, ok := concurrencySlots.Load(ctx.Conn()); ok {
+		return true
+	}
+	peer := listener.PeerIP(ctx.RemoteAddr())
+	if clientip.Contains(rateLimitAllowlist, peer) || (clientIPResolver != nil && clientIPResolver.IsTrusted(peer)) {
+		return true
+	}
+	key := ratelimit.Key(peer, viper.GetInt("rateLimit.subnetIPv4Bits"), viper.GetInt("rateLimit.subnetIPv6Bits"))
+	if !concurrencyLimiter.Acquire(key) {
+		tooManyRequests(ctx, 1)
+		ctx.SetConnectionClose()
+		return false
+	}
+	concurrencySlots.Store(ctx.Conn(), key)
+	return true
+}
+
+// releaseConcurrencySlot is the server's ConnState hook, which releases the
+// concurrency slot held by a connection once it is closed or hijacked.
+func releaseConcurrencySlot(c net.Conn, state fasthttp.ConnState) {
+	if state != fasthttp.StateClosed && state != fasthttp.StateHijacked {
+		return
+	}
+	if key, ok := concurrencySlots.Load(c); ok {
+		concurrencySlots.Delete(c)
+		concurrencyLimiter.Release(key.(string))
+	}
+}
+
+// tooManyRequests sends a 429 Too Many Requests response.
+func tooManyRequests(ctx *fasthttp.RequestCtx, retryAfter int) {
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(retryAfter))
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetContentType("text/plain; charset=utf8")
+	fmt.Fprint(ctx, "429 Too Many Requests")
+}
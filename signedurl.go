@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"owo.codes/whats-this/cdn-origin/lib/signedurl"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+var urlSigner *signedurl.Signer
+
+// signatureRequired returns true if requests for objects in bucket must be
+// signed.
+func signatureRequired(bucket string) bool {
+	if urlSigner == nil {
+		return false
+	}
+	for _, b := range viper.GetStringSlice("signedURLs.requiredBuckets") {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignature verifies the signature of a request for an object in bucket,
+// if the bucket requires signed URLs. Invalid requests get the same 403
+// Forbidden response whether or not the object exists, and false is returned.
+// Responses to signed requests are only cached privately until the URL
+// expires.
+func checkSignature(ctx *fasthttp.RequestCtx, bucket string) bool {
+	if !signatureRequired(bucket) {
+		return true
+	}
+	expires := string(ctx.QueryArgs().Peek(signedurl.ExpiresParam))
+	sig := string(ctx.QueryArgs().Peek(signedurl.SignatureParam))
+	now := time.Now()
+	if err := urlSigner.Verify(bucket, string(ctx.Path()), expires, sig, now); err != nil {
+		requestLogger(ctx).Debug().Err(err).Msg("rejected unsigned request")
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.Response.Header.Set("Cache-Control", "private, no-store")
+		ctx.SetContentType("text/plain; charset=utf8")
+		fmt.Fprint(ctx, "403 Forbidden")
+		return false
+	}
+	expiresUnix, _ := strconv.ParseInt(expires, 10, 64)
+	ctx.Response.Header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", expiresUnix-now.Unix()))
+	return true
+}
+
+// signedQuery returns the signature query parameters of a request, so URLs to
+// other variants of the same object keep working. An empty string is returned
+// for unsigned requests.
+func signedQuery(ctx *fasthttp.RequestCtx) string {
+	sig := ctx.QueryArgs().Peek(signedurl.SignatureParam)
+	if len(sig) == 0 {
+		return ""
+	}
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.SetBytesV(signedurl.ExpiresParam, ctx.QueryArgs().Peek(signedurl.ExpiresParam))
+	args.SetBytesV(signedurl.SignatureParam, sig)
+	return args.String()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"os"
+
+	"owo.codes/whats-this/cdn-origin/lib/db"
+	"owo.codes/whats-this/cdn-origin/lib/thumbnailer"
+	"owo.codes/whats-this/cdn-origin/lib/tracing"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+// tracer is the tracer for all spans, or nil if tracing is disabled.
+var tracer *tracing.Tracer
+
+// startRequestSpan starts the server span for a request. If the request has a
+// valid traceparent header, the span continues the caller's trace, and the
+// caller's span context is kept so it can still be propagated if the span
+// isn't sampled.
+func startRequestSpan(ctx *fasthttp.RequestCtx) *tracing.Span {
+	parent, ok := tracing.ParseTraceparent(string(ctx.Request.Header.Peek("traceparent")))
+	if ok {
+		ctx.SetUserValue("traceparent", parent)
+	}
+	span := tracer.Start(parent, "HTTP "+string(ctx.Method()), tracing.KindServer)
+	span.SetAttribute("http.method", string(ctx.Method()))
+	span.SetAttribute("http.target", string(ctx.RequestURI()))
+	span.SetAttribute("http.host", string(ctx.Host()))
+	span.SetAttribute("request_id", requestID(ctx))
+	ctx.SetUserValue("span", span)
+	return span
+}
+
+// endRequestSpan records the response status of a request on its server span
+// and ends it.
+func endRequestSpan(ctx *fasthttp.RequestCtx, span *tracing.Span) {
+	span.SetAttribute("http.status_code", ctx.Response.StatusCode())
+	if v, ok := ctx.UserValue("object_type").(string); ok {
+		span.SetAttribute("object_type", v)
+	}
+	if ctx.Response.StatusCode() >= 500 {
+		span.SetError(errStatus(ctx.Response.StatusCode()))
+	}
+	span.End()
+}
+
+// errStatus is an error for a failed response status code.
+type errStatus int
+
+func (e errStatus) Error() string {
+	return fasthttp.StatusMessage(int(e))
+}
+
+// startSpan starts a child span of the request's server span. If the request
+// isn't traced, nil is returned (which is safe to use).
+func startSpan(ctx *fasthttp.RequestCtx, name string, kind tracing.SpanKind) *tracing.Span {
+	parent, _ := ctx.UserValue("span").(*tracing.Span)
+	return tracer.StartChild(parent, name, kind)
+}
+
+// selectObjectByBucketKey calls db.SelectObjectByBucketKey in a span.
+func selectObjectByBucketKey(ctx *fasthttp.RequestCtx, bucket, key string) (db.Object, error) {
+	span := startSpan(ctx, "db.SelectObjectByBucketKey", tracing.KindClient)
+	defer span.End()
+	object, err := db.SelectObjectByBucketKey(bucket, key)
+	if err != sql.ErrNoRows {
+		span.SetError(err)
+	}
+	return object, err
+}
+
+// selectFileObjectBySHA256Hash calls db.SelectFileObjectBySHA256Hash in a span.
+func selectFileObjectBySHA256Hash(ctx *fasthttp.RequestCtx, bucket string, hash []byte) (db.Object, error) {
+	span := startSpan(ctx, "db.SelectFileObjectBySHA256Hash", tracing.KindClient)
+	defer span.End()
+	object, err := db.SelectFileObjectBySHA256Hash(bucket, hash)
+	if err != sql.ErrNoRows {
+		span.SetError(err)
+	}
+	return object, err
+}
+
+// decrementViews calls db.DecrementViews in a span.
+func decrementViews(ctx *fasthttp.RequestCtx, bucket, key string) (int64, error) {
+	span := startSpan(ctx, "db.DecrementViews", tracing.KindClient)
+	defer span.End()
+	views, err := db.DecrementViews(bucket, key)
+	if err != sql.ErrNoRows {
+		span.SetError(err)
+	}
+	return views, err
+}
+
+// openFile opens a stored file in a span.
+func openFile(ctx *fasthttp.RequestCtx, fPath string) (*os.File, error) {
+	span := startSpan(ctx, "storage.open", tracing.KindInternal)
+	defer span.End()
+	file, err := os.Open(fPath)
+	span.SetError(err)
+	return file, err
+}
+
+// serveFile calls fasthttp.ServeFileUncompressed in a span. The span only
+// covers opening the file and preparing the response, as the body is streamed
+// to the client after the handler returns.
+func serveFile(ctx *fasthttp.RequestCtx, fPath string) {
+	span := startSpan(ctx, "storage.serve", tracing.KindInternal)
+	defer span.End()
+	fasthttp.ServeFileUncompressed(ctx, fPath)
+	span.SetAttribute("http.response_content_length", ctx.Response.Header.ContentLength())
+}
+
+// getCachedThumbnail calls thumbnailCache.GetThumbnail in a span.
+func getCachedThumbnail(ctx *fasthttp.RequestCtx, key string) (io.ReadCloser, error) {
+	span := startSpan(ctx, "thumbnail.cache.get", tracing.KindInternal)
+	defer span.End()
+	thumb, err := thumbnailCache.GetThumbnail(key)
+	span.SetAttribute("cache_hit", err == nil)
+	if err != thumbnailer.NoCachedCopy {
+		span.SetError(err)
+	}
+	return thumb, err
+}
+
+// transformCachedThumbnail calls thumbnailCache.Transform in a span, propagating
+// the span to the thumbnailer service.
+func transformCachedThumbnail(ctx *fasthttp.RequestCtx, key, contentType string, data io.Reader) error {
+	span := startSpan(ctx, "thumbnailer.transform", tracing.KindClient)
+	defer span.End()
+	err := thumbnailCache.Transform(key, contentType, data, thumbnailerHeaders(ctx, span))
+	span.SetError(err)
+	return err
+}
+
+// transformThumbnail calls thumbnailer.Transform in a span, propagating the
+// span to the thumbnailer service.
+func transformThumbnail(ctx *fasthttp.RequestCtx, contentType string, data io.Reader) (*bytes.Buffer, error) {
+	span := startSpan(ctx, "thumbnailer.transform", tracing.KindClient)
+	defer span.End()
+	thumb, err := thumbnailer.Transform(viper.GetString("thumbnails.thumbnailerURL"), contentType, data,
+		thumbnailerHeaders(ctx, span))
+	span.SetError(err)
+	return thumb, err
+}
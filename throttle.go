@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"owo.codes/whats-this/cdn-origin/lib/clientip"
+	"owo.codes/whats-this/cdn-origin/lib/throttle"
+
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+var globalBandwidth *throttle.Bucket
+var bandwidthAllowlist []*net.IPNet
+var bandwidthAllowlistHosts map[string]struct{}
+
+// setupBandwidth creates the shared bandwidth limit and allowlists from the
+// bandwidth configuration.
+func setupBandwidth() error {
+	var err error
+	bandwidthAllowlist, err = clientip.ParseCIDRs(viper.GetStringSlice("bandwidth.allowlist"))
+	if err != nil {
+		return fmt.Errorf("invalid bandwidth.allowlist: %s", err)
+	}
+	bandwidthAllowlistHosts = make(map[string]struct{})
+	for _, host := range viper.GetStringSlice("bandwidth.allowlistHosts") {
+		bandwidthAllowlistHosts[strings.ToLower(host)] = struct{}{}
+	}
+	if rate := viper.GetInt64("bandwidth.globalRate"); rate > 0 {
+		globalBandwidth = throttle.NewBucket(rate)
+	}
+	return nil
+}
+
+// resetThrottle removes the bandwidth limits left on a connection by its
+// previous response.
+func resetThrottle(ctx *fasthttp.RequestCtx) {
+	if conn := throttle.From(ctx.Conn()); conn != nil {
+		conn.Unthrottle()
+	}
+}
+
+// throttleResponse applies the bandwidth limits to a file response. Files
+// smaller than bandwidth.minSize are sent at full speed. Allowlisted clients
+// and hosts get their own per-connection limit and don't count towards the
+// global limit.
+func throttleResponse(ctx *fasthttp.RequestCtx) {
+	conn := throttle.From(ctx.Conn())
+	if conn == nil {
+		return
+	}
+	if size := ctx.Response.Header.ContentLength(); size >= 0 && int64(size) < viper.GetInt64("bandwidth.minSize") {
+		return
+	}
+
+	if bandwidthAllowlisted(ctx) {
+		if rate := viper.GetInt64("bandwidth.allowlistRate"); rate > 0 {
+			conn.Throttle(throttle.NewBucket(rate))
+		}
+		return
+	}
+	var buckets []*throttle.Bucket
+	if rate := viper.GetInt64("bandwidth.perConnectionRate"); rate > 0 {
+		buckets = append(buckets, throttle.NewBucket(rate))
+	}
+	if globalBandwidth != nil {
+		buckets = append(buckets, globalBandwidth)
+	}
+	conn.Throttle(buckets...)
+}
+
+// bandwidthAllowlisted returns true if the client IP or requested host is
+// allowlisted for higher bandwidth limits.
+func bandwidthAllowlisted(ctx *fasthttp.RequestCtx) bool {
+	host := strings.ToLower(string(ctx.Request.Header.Host()))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, ok := bandwidthAllowlistHosts[host]; ok {
+		return true
+	}
+	return clientip.Contains(bandwidthAllowlist, clientIP(ctx))
+}